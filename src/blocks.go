@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// One of the named tables in a file. Each block has its own coordinates,
+// but they're stored one after the other in the same grid, so referances
+// between them are evaluated together.
+type TableBlock struct {
+    name string
+    start int
+    rows int
+    columns int
+
+    // Blocks from included files aren't printed.
+    included bool
+}
+
+type blockSource struct {
+    name string
+    records [][]string
+    started bool
+    included bool
+
+    // Read from CSV or TSV, where every record is a row of cells.
+    delimited bool
+
+    // Where each cell of each record starts in its file, for diagnostics.
+    file string
+    positions [][]SourcePosition
+}
+
+// Splits a line into its cells, along with the byte offset each one
+// starts at. Directives and '...' lines are kept whole.
+func lineRecord(line string) ([]string, []int) {
+    if isDirective(line) || strings.HasPrefix(line, "...") {
+        return []string { line }, []int { 0 }
+    }
+
+    cells := strings.Split(line, "|")
+    offsets := make([]int, len(cells))
+    start := 0
+    for i := range cells {
+        cell := cells[i]
+        cells[i] = strings.TrimSpace(cell)
+        offsets[i] = start + len(cell) - len(strings.TrimLeftFunc(cell, unicode.IsSpace))
+        start += len(cell) + 1
+    }
+    return cells, offsets
+}
+
+func (source *blockSource) directive(record []string) (string, bool) {
+    if source.delimited || len(record) != 1 || !isDirective(record[0]) {
+        return "", false
+    }
+    return record[0], true
+}
+
+func (source *blockSource) cloneLine(record []string) (string, bool) {
+    if source.delimited || len(record) != 1 || !strings.HasPrefix(record[0], "...") {
+        return "", false
+    }
+    return record[0], true
+}
+
+// Reads a '[Name]' line, which starts a new block.
+func parseBlockHeader(line string) (string, bool) {
+    if len(line) < 3 || line[0] != '[' || line[len(line) - 1] != ']' {
+        return "", false
+    }
+
+    name := line[1:len(line) - 1]
+    if !isLetter(name[0]) {
+        return "", false
+    }
+    for i := 0; i < len(name); i++ {
+        if !isHeaderChar(name[i]) {
+            return "", false
+        }
+    }
+
+    return name, true
+}
+
+// Splits a file into blocks at each '[Name]' line. Anything before the
+// first one goes in an unnamed block. Blank lines at the start or end of
+// a block are left out of it.
+func splitBlocks(input string) ([]blockSource, error) {
+    blocks := []blockSource { {} }
+    blank := 0
+
+    scanner := bufio.NewScanner(strings.NewReader(input))
+    for lineNumber := 1; scanner.Scan(); lineNumber++ {
+        raw := scanner.Text()
+        line := strings.TrimSpace(raw)
+        indent := len(raw) - len(strings.TrimLeftFunc(raw, unicode.IsSpace))
+        if name, ok := parseBlockHeader(line); ok {
+            for _, block := range blocks {
+                if strings.EqualFold(block.name, name) {
+                    return nil, fmt.Errorf("Table '%s' is already defined", name)
+                }
+            }
+
+            blocks = append(blocks, blockSource { name: name })
+            blank = 0
+            continue
+        }
+
+        if len(line) == 0 {
+            blank += 1
+            continue
+        }
+
+        block := &blocks[len(blocks) - 1]
+        if block.started {
+            for ; blank > 0; blank-- {
+                block.records = append(block.records, []string { "" })
+                block.positions = append(block.positions,
+                    []SourcePosition { { lineNumber - blank, 1 } })
+            }
+        }
+
+        blank = 0
+        record, offsets := lineRecord(line)
+        positions := make([]SourcePosition, len(offsets))
+        for i, offset := range offsets {
+            positions[i] = SourcePosition { lineNumber, indent + offset + 1 }
+        }
+
+        block.records = append(block.records, record)
+        block.positions = append(block.positions, positions)
+        block.started = block.started || !isDirective(line)
+    }
+
+    if len(blocks) > 1 && len(blocks[0].records) == 0 {
+        blocks = blocks[1:]
+    }
+    return blocks, nil
+}
+
+func (table *Table) findBlock(name string) (int, bool) {
+    for i, block := range table.blocks {
+        if strings.EqualFold(block.name, name) {
+            return i, true
+        }
+    }
+    return -1, false
+}
+
+func (table *Table) blockAt(row int) int {
+    for i, block := range table.blocks {
+        if row >= block.start && row < block.start + block.rows {
+            return i
+        }
+    }
+    return -1
+}
+
+// Checks a position is inside one block's own rows and columns, so a
+// shifted referance can't reach into the block next to it.
+func (table *Table) inBlock(position CellPosition, block int) bool {
+    b := table.blocks[block]
+    return position.row >= b.start && position.row < b.start + b.rows &&
+        position.column >= 0 && position.column < b.columns
+}
+
+// The cell a clone copies, which has to be in the same block as it.
+func (table *Table) cloneSource(position CellPosition, direction Direction, offset int) *Cell {
+    source := position.Offset(direction, offset)
+    if block := table.blockAt(position.row); block == -1 || !table.inBlock(source, block) {
+        cell := errorCell(errors.New("Cell outside table"))
+        return &cell
+    }
+    return table.CellAt(source)
+}
+
+// Formats the position relative to its block, such as Data!B3.
+func (table *Table) addressOf(position CellPosition) string {
+    index := table.blockAt(position.row)
+    if index == -1 {
+        return position.String()
+    }
+
+    block := table.blocks[index]
+    local := CellPosition { position.row - block.start, position.column }
+    if block.name == "" {
+        return local.String()
+    }
+    return block.name + "!" + local.String()
+}
+
+// Moves an expression parsed in its block's own coordinates to where the
+// block sits in the table. Referances to another table move to that one.
+func (table *Table) placeExpression(expression *Expression, block int) error {
+    if expression == nil {
+        return nil
+    }
+
+    target := block
+    if expression.table != "" {
+        other, found := table.findBlock(expression.table)
+        if !found {
+            return fmt.Errorf("Unknown table '%s'", expression.table)
+        }
+        target = other
+    }
+
+    start := table.blocks[target].start
+    switch expression.kind {
+    case ExpressionCell, ExpressionHeader:
+        expression.position.row += start
+        expression.block = target
+        return nil
+    case ExpressionRange:
+        expression.cellRange.start.row += start
+        expression.cellRange.end.row += start
+        expression.cellRange.block = target
+        return nil
+    case ExpressionFunction:
+        for _, argument := range expression.arguments {
+            if err := table.placeExpression(argument, block); err != nil {
+                return err
+            }
+        }
+        return nil
+    default:
+        if err := table.placeExpression(expression.lhs, block); err != nil {
+            return err
+        }
+        return table.placeExpression(expression.rhs, block)
+    }
+}
+
+// Parses a cell using its block's own coordinates, then places it in
+// the table.
+func (table *Table) parseCellAt(text string, block int, position CellPosition) Cell {
+    cell := parseCell(&table.allocator, text, position)
+    if cell.kind == CellExpression {
+        if err := table.placeExpression(cell.expression, block); err != nil {
+            return errorCell(err)
+        }
+    }
+    return cell
+}
@@ -55,11 +55,18 @@ type Cell struct {
     kind CellKind
     evaluationState EvaluationState
 
-    text string
-    number float64
+    // Text, numbers and errors keep what they hold here, as do
+    // expressions once they've been evaluated.
+    value Value
     direction Direction
     offset int
-    err error
+
+    // Set once a clone has been replaced by a copy of its source, which
+    // direction and offset still point at.
+    cloned bool
+    // Shared between every cell in a column with the same format, or nil
+    // if the cell doesn't have one.
+    format *NumberFormat
 
     expression *Expression
     expressionOffset CellPosition
@@ -86,26 +93,44 @@ func (position CellPosition) Offset(direction Direction, count int) CellPosition
     }
 }
 
-func formatCellNumber(number float64) string {
-    return strconv.FormatFloat(number, 'f', -1, 32)
+// The value other cells see when referencing this one.
+func (cell Cell) Value() Value {
+    switch cell.kind {
+    case CellText, CellNumber, CellError:
+        return cell.value
+    case CellExpression:
+        if cell.evaluationState != EvaluationDone {
+            return ErrorValue(errors.New("Cell has not been evaluated"))
+        }
+        return cell.value
+    default:
+        return Value{}
+    }
+}
+
+func (cell Cell) formatValue(value Value) string {
+    if value.kind == ValueNumber && cell.format != nil {
+        return cell.format.Format(value.number)
+    }
+    return value.String()
 }
 
 func (cell Cell) String() string {
     switch cell.kind {
     case CellText:
-        return cell.text
+        return cell.value.text
     case CellNumber:
-        return formatCellNumber(cell.number)
+        return cell.formatValue(cell.value)
     case CellExpression:
         if cell.evaluationState == EvaluationDone {
-            return formatCellNumber(cell.number)
+            return cell.formatValue(cell.value)
         } else {
             return "#ERROR#"
         }
     case CellSeporator:
         return ""
     case CellError:
-        return "#" + fmt.Sprint(cell.err) + "#"
+        return cell.value.String()
     case CellEmpty:
         return ""
     default:
@@ -113,12 +138,16 @@ func (cell Cell) String() string {
     }
 }
 
+func errorCell(err error) Cell {
+    return Cell { kind: CellError, value: ErrorValue(err) }
+}
+
 func (cell *Cell) Offset(direction Direction, offset int) {
     if cell.kind == CellExpression {
         cell.expressionOffset = cell.expressionOffset.Offset(
             direction.Reverse(), offset)
         cell.evaluationState = EvaluationPending
-        cell.number = 0
+        cell.value = Value{}
     }
 }
 
@@ -142,26 +171,56 @@ func parseDirection(text string) (Direction, error) {
     }
 }
 
+// Gives a parse error the byte column it was found at in the cell's text.
+// Errors that don't know where they were found are put where the parser
+// stopped, at the start of rest.
+func locateError(err error, text string, rest string) error {
+    located := SyntaxError { message: err.Error(), rest: rest }
+    if syntax, ok := err.(*SyntaxError); ok {
+        located.rest = syntax.rest
+    }
+
+    located.column = len(text) - len(located.rest)
+    return &located
+}
+
+// Reads a cell, along with the number format after a ';' at its end. If
+// text doesn't have a valid format, the ';' is just part of the text.
 func parseCell(allocator *ExpressionAllocator,
                text string,
                position CellPosition) Cell {
+    body, format, found := cutNumberFormat(text)
+    if !found {
+        return parseCellContent(allocator, text, position)
+    }
+
+    cell := parseCellContent(allocator, body, position)
+    format.column = cell.kind == CellText
+    cell.format = &format
+    return cell
+}
+
+func parseCellContent(allocator *ExpressionAllocator,
+                      text string,
+                      position CellPosition) Cell {
     if len(text) == 0 {
         return Cell { kind: CellEmpty }
     }
 
     if text[0] == '=' {
-        expression, _, err := parseExpression(allocator, text[1:], position)
+        expression, rest, err := parseCellExpression(allocator, text[1:], position)
         if err != nil {
-            return Cell { kind: CellError, err: err }
+            return errorCell(locateError(err, text, rest))
         }
 
+        locateNames(expression, len(text))
         return Cell { kind: CellExpression, expression: expression }
     }
 
     if text[0] == ':' {
         direction, err := parseDirection(text[1:]) 
         if err != nil {
-            return Cell { kind: CellError, err: err }
+            return errorCell(locateError(err, text, text[1:]))
         }
 
         offset := 1
@@ -177,9 +236,26 @@ func parseCell(allocator *ExpressionAllocator,
 
     number, err := strconv.ParseFloat(text, 64)
     if err == nil {
-        return Cell { kind: CellNumber, number: number }
+        return Cell { kind: CellNumber, value: NumberValue(number) }
     }
 
-    return Cell { kind: CellText, text: text }
+    return Cell { kind: CellText, value: StringValue(text) }
 }
 
+
+func formatColumnName(column int) string {
+    name := make([]byte, 0, maxColumnNameLength)
+    for column += 1; column > 0; column = (column - 1) / 26 {
+        name = append(name, byte('A' + (column - 1) % 26))
+    }
+
+    for i, j := 0, len(name) - 1; i < j; i, j = i + 1, j - 1 {
+        name[i], name[j] = name[j], name[i]
+    }
+    return string(name)
+}
+
+// Formats the position in A1 notation, such as AB12.
+func (position CellPosition) String() string {
+    return formatColumnName(position.column) + strconv.Itoa(position.row + 1)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+)
+
+// Picks the input format from the file's extension, unless one is given.
+func inputFormat(filePath string, format string) (string, error) {
+    if format == "" {
+        switch strings.ToLower(filepath.Ext(filePath)) {
+        case ".csv": return "csv", nil
+        case ".tsv": return "tsv", nil
+        default: return "cell", nil
+        }
+    }
+
+    switch format {
+    case "cell", "csv", "tsv":
+        return format, nil
+    default:
+        return "", fmt.Errorf("Unknown input format '%s'", format)
+    }
+}
+
+func isQuotedField(lines []string, line int, column int) bool {
+    return line <= len(lines) && column <= len(lines[line - 1]) &&
+        lines[line - 1][column - 1] == '"'
+}
+
+// Reads a CSV or TSV file as a single block, one row per record. Cells
+// starting with '=' or ':' are still read as expressions and clones.
+func readDelimitedSources(filePath string, comma rune) ([]blockSource, error) {
+    input, err := os.ReadFile(filePath)
+    if err != nil {
+        return nil, err
+    }
+
+    // Kept to check if a field is quoted, as its position is the quote's.
+    lines := strings.Split(string(input), "\n")
+    reader := csv.NewReader(strings.NewReader(string(input)))
+    reader.Comma = comma
+    reader.FieldsPerRecord = -1
+    if comma == '\t' {
+        reader.LazyQuotes = true
+    }
+
+    source := blockSource {
+        delimited: true,
+        file: displayPath(filePath),
+    }
+    for {
+        record, err := reader.Read()
+        if err == io.EOF {
+            break
+        }
+        if err != nil {
+            return nil, err
+        }
+
+        positions := make([]SourcePosition, len(record))
+        for i := range record {
+            line, column := reader.FieldPos(i)
+            if isQuotedField(lines, line, column) {
+                column += 1
+            }
+            column += len(record[i]) - len(strings.TrimLeftFunc(record[i], unicode.IsSpace))
+            positions[i] = SourcePosition { line, column }
+            record[i] = strings.TrimSpace(record[i])
+        }
+
+        source.records = append(source.records, record)
+        source.positions = append(source.positions, positions)
+    }
+
+    return []blockSource { source }, nil
+}
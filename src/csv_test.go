@@ -0,0 +1,33 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDelimitedPositions(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "bad.csv")
+    text := "A, B\n\"=1 + )\",  =2 +\n"
+    if err := os.WriteFile(path, []byte(text), 0644); err != nil {
+        t.Fatal(err)
+    }
+
+    table, err := readTable(path, "")
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    want := []SourcePosition { { 2, 7 }, { 2, 16 } }
+    diagnostics := table.Diagnostics()
+    if len(diagnostics) != len(want) {
+        t.Fatalf("got %d diagnostics, want %d: %v", len(diagnostics), len(want), diagnostics)
+    }
+    for i, diagnostic := range diagnostics {
+        if diagnostic.position != want[i] {
+            t.Errorf("diagnostic %d is at %d:%d, want %d:%d", i,
+                diagnostic.position.line, diagnostic.position.column,
+                want[i].line, want[i].column)
+        }
+    }
+}
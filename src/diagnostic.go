@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// A line and byte column in a source file, both counting from 1.
+type SourcePosition struct {
+    line int
+    column int
+}
+
+// A cell or directive that couldn't be read, and where it is.
+type Diagnostic struct {
+    file string
+    position SourcePosition
+
+    // The cell's address, like Data!B3, or empty for a directive.
+    cell string
+
+    message string
+}
+
+// Formats the diagnostic like a compiler would, as 'test.cell:7:14: msg',
+// followed by the cell it's in.
+func (diagnostic Diagnostic) String() string {
+    location := fmt.Sprintf("%s:%d:%d",
+        diagnostic.file, diagnostic.position.line, diagnostic.position.column)
+    if diagnostic.cell == "" {
+        return fmt.Sprintf("%s: %s", location, diagnostic.message)
+    }
+    return fmt.Sprintf("%s: %s (in %s)", location, diagnostic.message, diagnostic.cell)
+}
+
+func (diagnostic Diagnostic) Error() string {
+    return diagnostic.String()
+}
+
+// Shortens a path to be relative to the working directory, if it's
+// inside it.
+func displayPath(path string) string {
+    directory, err := os.Getwd()
+    if err != nil {
+        return path
+    }
+
+    absolute, err := filepath.Abs(path)
+    if err != nil {
+        return path
+    }
+
+    relative, err := filepath.Rel(directory, absolute)
+    if err != nil || strings.HasPrefix(relative, "..") {
+        return path
+    }
+    return relative
+}
+
+// Finds each directive and cell written in the source that couldn't be
+// read, or that will fail however it's evaluated, like a call to a
+// function that doesn't exist. This should be called before the table is
+// evaluated, as after that clones of these cells are errors too.
+func (table *Table) Diagnostics() []Diagnostic {
+    diagnostics := make([]Diagnostic, 0)
+    for block := range table.sources {
+        source := &table.sources[block]
+        row := table.blocks[block].start
+        for i, record := range source.records {
+            if _, ok := source.directive(record); ok {
+                diagnostics = append(diagnostics, table.directiveDiagnostics(source, i)...)
+                continue
+            }
+            if line, ok := source.cloneLine(record); ok {
+                count, _ := strconv.ParseUint(strings.TrimSpace(line[3:]), 10, 32)
+                row += int(count)
+                continue
+            }
+
+            for column := range record {
+                position := CellPosition { row, column }
+                diagnostic := Diagnostic {
+                    file: source.file,
+                    position: source.positions[i][column],
+                    cell: table.addressOf(position),
+                }
+
+                cell := table.CellAt(position)
+                switch cell.kind {
+                case CellError:
+                    diagnostic.message = cell.value.err.Error()
+                    if syntax, ok := cell.value.err.(*SyntaxError); ok {
+                        diagnostic.position.column += syntax.column
+                    }
+                    diagnostics = append(diagnostics, diagnostic)
+                case CellExpression:
+                    diagnostics = table.expressionDiagnostics(
+                        cell.expression, diagnostic, diagnostics)
+                }
+            }
+
+            row += 1
+        }
+    }
+
+    return diagnostics
+}
+
+// The diagnostics kept while reading the directive on a source's record.
+func (table *Table) directiveDiagnostics(source *blockSource, record int) []Diagnostic {
+    position := source.positions[record][0]
+    for i, diagnostic := range table.diagnostics {
+        if diagnostic.file == source.file && diagnostic.position == position {
+            return table.diagnostics[i:i+1]
+        }
+    }
+    return nil
+}
+
+// Adds a diagnostic for each name and header in the expression that
+// wasn't found, and each call that can't be made.
+func (table *Table) expressionDiagnostics(expression *Expression,
+                                          cell Diagnostic,
+                                          diagnostics []Diagnostic) []Diagnostic {
+    if expression == nil {
+        return diagnostics
+    }
+
+    var err error
+    switch expression.kind {
+    case ExpressionName:
+        err = fmt.Errorf("Unknown name '%s'", expression.name)
+    case ExpressionHeader:
+        err = fmt.Errorf("Unknown column '%s'", expression.name)
+    case ExpressionFunction:
+        _, err = table.lookupCall(expression)
+    }
+    if err != nil {
+        diagnostic := cell
+        diagnostic.message = err.Error()
+        diagnostic.position.column += expression.column
+        diagnostics = append(diagnostics, diagnostic)
+    }
+
+    for _, argument := range expression.arguments {
+        diagnostics = table.expressionDiagnostics(argument, cell, diagnostics)
+    }
+    diagnostics = table.expressionDiagnostics(expression.lhs, cell, diagnostics)
+    return table.expressionDiagnostics(expression.rhs, cell, diagnostics)
+}
@@ -4,210 +4,404 @@ import (
 	"errors"
 	"fmt"
 	"math"
-	"strings"
 )
 
 func (table *Table) EvaluateCellReferance(expression *Expression,
-                                          shiftOffset CellPosition) (float64, error) {
-    position := CellPosition {
-        expression.position.row + shiftOffset.row,
-        expression.position.column + shiftOffset.column,
-    }
-
-    table.EnsureEvaluated(position)
-    cell := table.CellAt(position)
-
-    switch cell.kind {
-    case CellText:
-        return -1, errors.New("Cannot operate on text")
-    case CellNumber:
-        return cell.number, nil
-    case CellExpression:
-        return cell.number, nil
-    case CellError:
-        return -1, cell.err
-    case CellEmpty:
-        return 0, nil
-    default:
-        panic(0)
+                                          shiftOffset CellPosition) (Value, error) {
+    position := expression.anchors.Shift(expression.position, shiftOffset)
+    if !table.inBlock(position, expression.block) {
+        return Value{}, errors.New("Cell outside table")
+    }
+
+    value := table.CellAt(position).Value()
+    if value.kind == ValueError {
+        return Value{}, value.err
     }
+
+    return value, nil
 }
 
-func (table *Table) EvaluateOperation(operation func(float64, float64) float64,
-                                      expression *Expression,
-                                      shiftOffset CellPosition) (float64, error) {
+func (table *Table) EvaluateOperands(expression *Expression,
+                                     shiftOffset CellPosition) (Value, Value, error) {
     lhs, err := table.EvaluateExpression(expression.lhs, shiftOffset)
     if err != nil {
-        return -1, err
+        return Value{}, Value{}, err
     }
 
     rhs, err := table.EvaluateExpression(expression.rhs, shiftOffset)
     if err != nil {
-        return -1, err
+        return Value{}, Value{}, err
+    }
+
+    return lhs, rhs, nil
+}
+
+func (table *Table) EvaluateOperation(operation func(float64, float64) (float64, error),
+                                      expression *Expression,
+                                      shiftOffset CellPosition) (Value, error) {
+    lhs, rhs, err := table.EvaluateOperands(expression, shiftOffset)
+    if err != nil {
+        return Value{}, err
+    }
+
+    a, err := lhs.AsNumber()
+    if err != nil {
+        return Value{}, err
+    }
+
+    b, err := rhs.AsNumber()
+    if err != nil {
+        return Value{}, err
     }
     
-    return operation(lhs, rhs), nil
+    result, err := operation(a, b)
+    if err != nil {
+        return Value{}, err
+    }
+
+    return NumberValue(result), nil
 }
 
-func add_operation(a float64, b float64) float64 {
-    return a + b
+func (table *Table) EvaluateComparison(test func(int) bool,
+                                       expression *Expression,
+                                       shiftOffset CellPosition) (Value, error) {
+    lhs, rhs, err := table.EvaluateOperands(expression, shiftOffset)
+    if err != nil {
+        return Value{}, err
+    }
+
+    order, err := compareValues(lhs, rhs)
+    if err != nil {
+        return Value{}, err
+    }
+
+    return BooleanValue(test(order)), nil
 }
 
-type Argument struct {
-    is_range bool
-    value float64
-    cellRange Range
+// Values of different kinds are never equal, rather than an error.
+func (table *Table) EvaluateEquality(equal bool,
+                                     expression *Expression,
+                                     shiftOffset CellPosition) (Value, error) {
+    lhs, rhs, err := table.EvaluateOperands(expression, shiftOffset)
+    if err != nil {
+        return Value{}, err
+    }
+
+    if (lhs.kind == ValueString && !rhs.isText()) ||
+        (rhs.kind == ValueString && !lhs.isText()) {
+        return BooleanValue(!equal), nil
+    }
+
+    order, err := compareValues(lhs, rhs)
+    if err != nil {
+        return Value{}, err
+    }
+
+    return BooleanValue((order == 0) == equal), nil
 }
 
-type Function struct {
-    function func(*Table, []*Expression, CellPosition) float64
-    expected_arguments []bool
+func (table *Table) EvaluateConcat(expression *Expression,
+                                   shiftOffset CellPosition) (Value, error) {
+    lhs, rhs, err := table.EvaluateOperands(expression, shiftOffset)
+    if err != nil {
+        return Value{}, err
+    }
+
+    return StringValue(lhs.String() + rhs.String()), nil
 }
 
-func sum(table *Table,
-         arguments []*Expression,
-         shiftOffset CellPosition) float64 {
-    total := 0.0
-    a := arguments[0].cellRange.Shift(shiftOffset)
-    for row := a.start.row; row <= a.end.row; row++ {
-        for column := a.start.column; column <= a.end.column; column++ {
-            table.EnsureEvaluated(CellPosition { row, column })
-            total += table.CellAt(CellPosition { row, column }).number
-        }
+func (table *Table) EvaluateNegate(expression *Expression,
+                                   shiftOffset CellPosition) (Value, error) {
+    value, err := table.EvaluateExpression(expression.lhs, shiftOffset)
+    if err != nil {
+        return Value{}, err
     }
 
-    return total
+    number, err := value.AsNumber()
+    if err != nil {
+        return Value{}, err
+    }
+
+    return NumberValue(-number), nil
 }
 
-func sqrt(table *Table,
-          arguments []*Expression,
-          shiftOffset CellPosition) float64 {
-    value, _ := table.EvaluateExpression(
-        arguments[0], shiftOffset)
-    return math.Sqrt(value)
+func (table *Table) EvaluateNot(expression *Expression,
+                                shiftOffset CellPosition) (Value, error) {
+    value, err := table.EvaluateExpression(expression.lhs, shiftOffset)
+    if err != nil {
+        return Value{}, err
+    }
+
+    boolean, err := value.AsBoolean()
+    if err != nil {
+        return Value{}, err
+    }
+
+    return BooleanValue(!boolean), nil
 }
 
-func (table *Table) GetFunction(name string) (Function, bool) {
-    switch strings.ToLower(name) {
-    case "sum":
-        return Function {
-            function: sum,
-            expected_arguments: []bool { true },
-        }, true
-    case "sqrt":
-        return Function {
-            function: sqrt,
-            expected_arguments: []bool { false },
-        }, true
-    default:
-        return Function{}, false
+// Only evaluates the rhs if the lhs doesn't already decide the result.
+func (table *Table) EvaluateLogical(expression *Expression,
+                                    shiftOffset CellPosition) (Value, error) {
+    value, err := table.EvaluateExpression(expression.lhs, shiftOffset)
+    if err != nil {
+        return Value{}, err
+    }
+
+    lhs, err := value.AsBoolean()
+    if err != nil {
+        return Value{}, err
+    }
+
+    if expression.kind == ExpressionAnd && !lhs {
+        return BooleanValue(false), nil
+    }
+    if expression.kind == ExpressionOr && lhs {
+        return BooleanValue(true), nil
+    }
+
+    value, err = table.EvaluateExpression(expression.rhs, shiftOffset)
+    if err != nil {
+        return Value{}, err
+    }
+
+    rhs, err := value.AsBoolean()
+    if err != nil {
+        return Value{}, err
+    }
+
+    return BooleanValue(rhs), nil
+}
+
+// Gives an error for a result that's too large to be a number, rather
+// than letting Inf or NaN into the table.
+func checkResult(result float64) (float64, error) {
+    if math.IsNaN(result) || math.IsInf(result, 0) {
+        return -1, errors.New("Number too large")
+    }
+    return result, nil
+}
+
+func add_operation(a float64, b float64) (float64, error) {
+    return checkResult(a + b)
+}
+
+func subtract_operation(a float64, b float64) (float64, error) {
+    return checkResult(a - b)
+}
+
+func multiply_operation(a float64, b float64) (float64, error) {
+    return checkResult(a * b)
+}
+
+func divide_operation(a float64, b float64) (float64, error) {
+    if b == 0 {
+        return -1, errors.New("Division by zero")
+    }
+    return checkResult(a / b)
+}
+
+func modulo_operation(a float64, b float64) (float64, error) {
+    if b == 0 {
+        return -1, errors.New("Division by zero")
+    }
+    return checkResult(math.Mod(a, b))
+}
+
+func power_operation(a float64, b float64) (float64, error) {
+    result := math.Pow(a, b)
+    if math.IsNaN(result) || math.IsInf(result, 0) {
+        return -1, fmt.Errorf("Cannot raise %s to the power of %s",
+            formatCellNumber(a), formatCellNumber(b))
     }
+    return result, nil
+}
+
+func less_order(order int) bool {
+    return order < 0
+}
+
+func greater_order(order int) bool {
+    return order > 0
+}
+
+func less_equal_order(order int) bool {
+    return order <= 0
+}
+
+func greater_equal_order(order int) bool {
+    return order >= 0
+}
+
+func (table *Table) GetFunction(name string) (Function, bool) {
+    return table.functions.Lookup(name)
 }
 
 func validateArguments(name string, function Function, arguments []*Expression) error {
-    if len(arguments) != len(function.expected_arguments) {
+    expected := len(function.arguments)
+    if function.variadic && len(arguments) < expected {
+        return fmt.Errorf(
+            "Function '%s' takes at least %d argument(s), got %d",
+            name, expected, len(arguments))
+    }
+    if !function.variadic && len(arguments) != expected {
         return fmt.Errorf(
             "Function '%s' takes %d argument(s), got %d",
-            name,
-            len(function.expected_arguments),
-            len(arguments))
+            name, expected, len(arguments))
     }
 
-    for i := range arguments {
-        if (arguments[i].kind == ExpressionRange) != function.expected_arguments[i] {
+    for i, argument := range arguments {
+        kind := function.ArgumentKind(i)
+        if !kind.Accepts(argument) {
+            given := "a value"
+            if argument.kind == ExpressionRange {
+                given = "a range"
+            }
+
             return fmt.Errorf(
-                "Function '%s' takes a value, not range",
-                name)
+                "Function '%s' argument %d should be %s, got %s instead",
+                name, i + 1, kind, given)
         }
     }
 
     return nil
 }
 
-func (table *Table) EvaluateFunction(expression *Expression,
-                                     shiftOffset CellPosition) (float64, error) {
+// Finds the function a call is to and checks its arguments, which doesn't
+// need anything to be evaluated.
+func (table *Table) lookupCall(expression *Expression) (Function, error) {
     name := expression.function
     function, found := table.GetFunction(name)
     if !found {
-        return -1, fmt.Errorf("Uknown function '%s'", name)
+        return Function{}, fmt.Errorf("Unknown function '%s'", name)
+    }
+    return function, validateArguments(name, function, expression.arguments)
+}
+
+func (table *Table) EvaluateFunction(expression *Expression,
+                                     shiftOffset CellPosition) (Value, error) {
+    name := expression.function
+    function, err := table.lookupCall(expression)
+    if err != nil {
+        return Value{}, err
     }
 
-    if err := validateArguments(name, function, expression.arguments); err != nil {
-        return -1, nil
+    value, err := function.function(table, expression.arguments, shiftOffset)
+    if argumentErr, ok := err.(ArgumentError); ok {
+        return Value{}, fmt.Errorf(
+            "Function '%s' argument %d: %s",
+            name, argumentErr.index + 1, argumentErr.err)
+    }
+    if err != nil {
+        return Value{}, fmt.Errorf("Function '%s': %s", name, err)
     }
 
-    return function.function(table, expression.arguments, shiftOffset), nil
+    if value.kind == ValueNumber {
+        if _, err := checkResult(value.number); err != nil {
+            return Value{}, fmt.Errorf("Function '%s': %s", name, err)
+        }
+    }
+    return value, nil
 }
 
 func (table *Table) EvaluateExpression(expression *Expression,
-                                       shiftOffset CellPosition) (float64, error) {
+                                       shiftOffset CellPosition) (Value, error) {
     switch expression.kind {
     case ExpressionAdd:
         return table.EvaluateOperation(
             add_operation, expression, shiftOffset)
+    case ExpressionSubtract:
+        return table.EvaluateOperation(
+            subtract_operation, expression, shiftOffset)
+    case ExpressionMultiply:
+        return table.EvaluateOperation(
+            multiply_operation, expression, shiftOffset)
+    case ExpressionDivide:
+        return table.EvaluateOperation(
+            divide_operation, expression, shiftOffset)
+    case ExpressionModulo:
+        return table.EvaluateOperation(
+            modulo_operation, expression, shiftOffset)
+    case ExpressionPower:
+        return table.EvaluateOperation(
+            power_operation, expression, shiftOffset)
+    case ExpressionNegate:
+        return table.EvaluateNegate(expression, shiftOffset)
+    case ExpressionLess:
+        return table.EvaluateComparison(
+            less_order, expression, shiftOffset)
+    case ExpressionGreater:
+        return table.EvaluateComparison(
+            greater_order, expression, shiftOffset)
+    case ExpressionLessEqual:
+        return table.EvaluateComparison(
+            less_equal_order, expression, shiftOffset)
+    case ExpressionGreaterEqual:
+        return table.EvaluateComparison(
+            greater_equal_order, expression, shiftOffset)
+    case ExpressionEqual:
+        return table.EvaluateEquality(true, expression, shiftOffset)
+    case ExpressionNotEqual:
+        return table.EvaluateEquality(false, expression, shiftOffset)
+    case ExpressionConcat:
+        return table.EvaluateConcat(expression, shiftOffset)
+    case ExpressionAnd, ExpressionOr:
+        return table.EvaluateLogical(expression, shiftOffset)
+    case ExpressionNot:
+        return table.EvaluateNot(expression, shiftOffset)
     case ExpressionNumber:
-        return expression.number, nil
+        return NumberValue(expression.number), nil
+    case ExpressionString:
+        return StringValue(expression.text), nil
     case ExpressionCell:
         return table.EvaluateCellReferance(expression, shiftOffset)
-    case ExpressionConstant:
-        return table.EvaluateCellReferance(expression, CellPosition{})
     case ExpressionRange:
-        return -1, errors.New("Ranges can only be used in functions")
+        return Value{}, errors.New("Ranges can only be used in functions")
     case ExpressionFunction:
         return table.EvaluateFunction(expression, shiftOffset)
+    case ExpressionName:
+        return Value{}, fmt.Errorf("Unknown name '%s'", expression.name)
+    case ExpressionHeader:
+        return Value{}, fmt.Errorf("Unknown column '%s'", expression.name)
     default:
         panic(0)
     }
 }
 
-func (table *Table) EvaluateCell(cell *Cell, position CellPosition) {
+func (table *Table) EvaluateCell(cell *Cell) {
     cell.evaluationState = EvaluationInProgress
-    switch cell.kind {
-    case CellExpression:
-        value, err := table.EvaluateExpression(
-            cell.expression, cell.expressionOffset)
-
-        cell.number = value
-        if err != nil {
-            *cell = Cell { kind: CellError, err: err }
-        }
-    case CellClone:
-        direction, offset := cell.direction, cell.offset
-        clone_position := position.Offset(direction, offset)
-        table.EnsureEvaluated(clone_position)
-
-        *cell = *table.CellAt(clone_position)
-        cell.Offset(direction, offset)
-        table.EnsureEvaluated(position)
-    default:
-        panic(0)
-    }
+    value, err := table.EvaluateExpression(
+        cell.expression, cell.expressionOffset)
 
+    cell.value = value
+    if err != nil {
+        cell.value = ErrorValue(err)
+    }
     cell.evaluationState = EvaluationDone
 }
 
-func (table *Table) EnsureEvaluated(position CellPosition) { 
-    cell := table.CellAt(position)
-    if cell.evaluationState == EvaluationDone {
-        return
-    }
-
-    if cell.kind != CellExpression && cell.kind != CellClone {
-        return
-    }
+func (table *Table) prepareEvaluation() []int {
+    table.clones = table.resolveClones()
+    table.graph = table.buildDependencyGraph()
+    table.evaluated = true
+    table.dirty = nil
 
-    if cell.evaluationState == EvaluationInProgress {
-        *cell = Cell { kind: CellError, err: errors.New("Loop!") }
-        return
-    }
-    table.EvaluateCell(cell, position)
+    states := make([]visitState, len(table.content))
+    return table.topologicalOrder(&table.graph, states)
 }
 
-func (table *Table) Evaluate() {
-    for row := 0; row < table.rows; row++ {
-        for column := 0; column < table.columns; column++ {
-            table.EnsureEvaluated(CellPosition { row, column })
+func (table *Table) evaluateInOrder(order []int) {
+    for _, index := range order {
+        cell := &table.content[index]
+        if cell.kind == CellExpression && cell.evaluationState != EvaluationDone {
+            table.EvaluateCell(cell)
         }
     }
 }
 
+// Evaluates cells in dependency order, so every referenced cell is done
+// by the time it's read and evaluation never has to recurse into another
+// cell.
+func (table *Table) Evaluate() {
+    table.evaluateInOrder(table.prepareEvaluation())
+}
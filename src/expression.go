@@ -10,33 +10,131 @@ import (
 type TokenKind int
 const (
     TokenAdd TokenKind = iota
+    TokenSubtract
+    TokenMultiply
+    TokenDivide
+    TokenModulo
+    TokenPower
+    TokenLess
+    TokenGreater
+    TokenLessEqual
+    TokenGreaterEqual
+    TokenEqual
+    TokenNotEqual
+    TokenAnd
+    TokenOr
+    TokenNot
+    TokenConcat
     TokenOpenBrace
     TokenCloseBrace
     TokenComma
     TokenName
     TokenNumber
+    TokenString
     TokenCell
-    TokenConstant
     TokenRange
+    TokenHeader
     TokenEmpty
 )
 
+var tokenNames = map[TokenKind]string {
+    TokenAdd: "'+'",
+    TokenSubtract: "'-'",
+    TokenMultiply: "'*'",
+    TokenDivide: "'/'",
+    TokenModulo: "'%'",
+    TokenPower: "'^'",
+    TokenLess: "'<'",
+    TokenGreater: "'>'",
+    TokenLessEqual: "'<='",
+    TokenGreaterEqual: "'>='",
+    TokenEqual: "'=='",
+    TokenNotEqual: "'!='",
+    TokenAnd: "'and'",
+    TokenOr: "'or'",
+    TokenNot: "'not'",
+    TokenConcat: "'&'",
+    TokenOpenBrace: "'('",
+    TokenCloseBrace: "')'",
+    TokenComma: "','",
+    TokenName: "name",
+    TokenNumber: "number",
+    TokenString: "string",
+    TokenCell: "cell referance",
+    TokenRange: "range",
+    TokenHeader: "column header",
+    TokenEmpty: "end of expression",
+}
+
+// The token's name as it's shown in error messages, like ')' or number.
+func (kind TokenKind) String() string {
+    if name, found := tokenNames[kind]; found {
+        return name
+    }
+    return "token " + strconv.Itoa(int(kind))
+}
+
+// An error parsing an expression. It keeps the text that was left to
+// parse when it was found, so its column in the cell can be worked out.
+type SyntaxError struct {
+    message string
+    rest string
+
+    // The byte offset of the error in the cell's text, once it's known.
+    column int
+}
+
+func (err *SyntaxError) Error() string {
+    return err.message
+}
+
+func syntaxError(rest string, format string, arguments ...interface{}) error {
+    return &SyntaxError {
+        message: fmt.Sprintf(format, arguments...),
+        rest: rest,
+    }
+}
+
+// Pins one or both axes of a reference with '$', so it doesn't move when
+// the expression is cloned.
+type Anchors struct {
+    row bool
+    column bool
+}
+
+func (anchors Anchors) Shift(position CellPosition, offset CellPosition) CellPosition {
+    if !anchors.row {
+        position.row += offset.row
+    }
+    if !anchors.column {
+        position.column += offset.column
+    }
+    return position
+}
+
 type Range struct {
     start CellPosition
     end CellPosition
+    startAnchors Anchors
+    endAnchors Anchors
+
+    // The end stretches to the last row or column of the table, or with
+    // toSeporator, down to the row above the next seporator.
+    openRow bool
+    openColumn bool
+    toSeporator bool
+
+    // The end was written relative to the cell, like A8:^2.
+    relativeEnd bool
+
+    // The block the range is in, which it can't be shifted out of.
+    block int
 }
 
 func (r Range) Shift(offset CellPosition) Range {
-    return Range {
-        CellPosition {
-            r.start.row + offset.row,
-            r.start.column + offset.column,
-        },
-        CellPosition {
-            r.end.row + offset.row,
-            r.end.column + offset.column,
-        },
-    }
+    r.start = r.startAnchors.Shift(r.start, offset)
+    r.end = r.endAnchors.Shift(r.end, offset)
+    return r
 }
 
 type Token struct {
@@ -44,35 +142,77 @@ type Token struct {
     name string
     number float64
     position CellPosition
+    anchors Anchors
     cellRange Range
+    table string
+    relative bool
 }
 
 type ExpressionKind int
 const (
     ExpressionAdd ExpressionKind = iota
+    ExpressionSubtract
+    ExpressionMultiply
+    ExpressionDivide
+    ExpressionModulo
+    ExpressionPower
+    ExpressionNegate
+    ExpressionLess
+    ExpressionGreater
+    ExpressionLessEqual
+    ExpressionGreaterEqual
+    ExpressionEqual
+    ExpressionNotEqual
+    ExpressionAnd
+    ExpressionOr
+    ExpressionNot
+    ExpressionConcat
     ExpressionNumber
+    ExpressionString
     ExpressionCell
-    ExpressionConstant
     ExpressionRange
     ExpressionFunction
+    ExpressionName
+    ExpressionHeader
 )
 
 type Expression struct {
     kind ExpressionKind
     number float64
+    text string
 
     lhs *Expression
     rhs *Expression
 
     position CellPosition
+    anchors Anchors
     cellRange Range
 
+    // Written relative to the cell, like ^2, rather than in A1 notation.
+    relative bool
+
     function string
     arguments []*Expression
+
+    // The table a referance is in, if it's not the one it's used from,
+    // and once it's been placed, the index of that table's block.
+    table string
+    block int
+
+    // Set for named referances, even once they've been resolved, along
+    // with if the name was a column header.
+    name string
+    header bool
+
+    // The byte offset of a name, header or function call in the cell's
+    // text. While parsing, it's how much of the text was left at it.
+    column int
 }
 
 const BlockSize = 1024;
 
+const maxColumnNameLength = 6
+
 type ExpressionAllocator struct {
     blocks [][]Expression
     used_in_current_block int
@@ -118,7 +258,7 @@ func parseName(text string) (Token, string, error) {
         i += 1
     }
 
-    if i < len(text) && isDigit(text[i]) {
+    if i < len(text) && (isDigit(text[i]) || text[i] == '$' || text[i] == ':') {
         return Token{}, text, errors.New("Not a name")
     }
 
@@ -128,29 +268,125 @@ func parseName(text string) (Token, string, error) {
     }, text[i:], nil
 }
 
-func parseRange(start CellPosition, text string, position CellPosition) (Token, string, error) {
-    var end Token
-    var err error
+// Reads the end of a range, which may leave out its row to run to the
+// bottom of the table, or use '_' to stop above the next seporator.
+func parseRange(start Token, text string, position CellPosition) (Token, string, error) {
+    cellRange := Range {
+        start: start.position,
+        startAnchors: start.anchors,
+    }
 
-    if isDirection(text[0]) {
-        end, text, err = parseRelativeCellReferance(text, position)
-    } else {
-        end, text, err = parseCellReference(text, position)
+    if len(text) > 0 && isDirection(text[0]) {
+        end, text, err := parseRelativeCellReferance(text, position)
+        if err != nil {
+            return Token{}, text, err
+        }
+
+        cellRange.end = end.position
+        cellRange.relativeEnd = true
+        return Token { kind: TokenRange, cellRange: cellRange }, text, nil
     }
 
+    if len(text) > 0 && text[0] == '$' {
+        cellRange.endAnchors.column = true
+        text = text[1:]
+    }
+
+    column, text, err := parseColumnName(text)
+    if err != nil {
+        return Token{}, text, err
+    }
+    cellRange.end.column = column
+
+    switch {
+    case len(text) > 0 && text[0] == '_':
+        cellRange.toSeporator = true
+        text = text[1:]
+    case len(text) > 0 && (isDigit(text[0]) || text[0] == '$'):
+        end, next_text, err := parseRow(text)
+        if err != nil {
+            return Token{}, text, err
+        }
+
+        cellRange.end.row = end.position.row
+        cellRange.endAnchors.row = end.anchors.row
+        text = next_text
+    default:
+        cellRange.openRow = true
+    }
+
+    return Token { kind: TokenRange, cellRange: cellRange }, text, nil
+}
+
+func parseRow(text string) (Token, string, error) {
+    anchors := Anchors{}
+    if len(text) > 0 && text[0] == '$' {
+        anchors.row = true
+        text = text[1:]
+    }
+
+    row, text, err := parseInt(text)
     if err != nil {
         return Token{}, text, err
     }
 
+    return Token {
+        kind: TokenCell,
+        position: CellPosition { row - 1, 0 },
+        anchors: anchors,
+    }, text, nil
+}
+
+// Reads a range of whole rows, like 3:5 or $3:$3.
+func parseRowRange(text string) (Token, string, error) {
+    start, text, err := parseRow(text)
+    if err != nil {
+        return Token{}, text, err
+    }
+
+    text, err = expectChar(':', text)
+    if err != nil {
+        return Token{}, text, err
+    }
+
+    end, text, err := parseRow(text)
+    if err != nil {
+        return Token{}, text, err
+    }
+
+    start.anchors.column = true
     return Token {
         kind: TokenRange,
         cellRange: Range {
-            start: start,
+            start: start.position,
             end: end.position,
+            startAnchors: start.anchors,
+            endAnchors: end.anchors,
+            openColumn: true,
         },
     }, text, nil
 }
 
+func isRowRange(text string) bool {
+    i := 0
+    if i < len(text) && text[i] == '$' {
+        i += 1
+    }
+
+    digits := i
+    for i < len(text) && isDigit(text[i]) {
+        i += 1
+    }
+    return i > digits && i < len(text) && text[i] == ':'
+}
+
+func expectChar(c byte, text string) (string, error) {
+    if len(text) == 0 || text[0] != c {
+        return text, syntaxError(text, "Expected '%c'", c)
+    }
+    return text[1:], nil
+}
+
 func parseInt(text string) (int, string, error) {
     i := 0
     for i < len(text) && isDigit(text[i]) {
@@ -188,33 +424,73 @@ func parseRelativeCellReferance(text string, position CellPosition) (Token, stri
     }
 
     refPosition := position.Offset(direction, offset)
-    return Token { kind: TokenCell, position: refPosition }, text, nil
+    return Token { kind: TokenCell, position: refPosition, relative: true }, text, nil
 }
 
-func parseConstantReferance(text string, position CellPosition) (Token, string, error) {
-    ref, text, err := parseCellReference(text[1:], position)
-    if err != nil {
-        return Token{}, text, err
+// Reads a spreadsheet style column name, where A is 0, Z is 25, AA is 26
+// and so on.
+func parseColumnName(text string) (int, string, error) {
+    i := 0
+    column := 0
+    for i < len(text) && isLetter(text[i]) {
+        letter := strings.ToUpper(text[i:i+1])[0]
+        column = column * 26 + int(letter - 'A') + 1
+        i += 1
     }
 
-    ref.kind = TokenConstant
-    return ref, text, nil
+    if i == 0 {
+        return -1, text, syntaxError(text, "Expected column name")
+    }
+    if i > maxColumnNameLength {
+        return -1, text, syntaxError(text, "Column name '%s' is too long", text[:i])
+    }
+
+    return column - 1, text[i:], nil
 }
 
+// Reads an A1 style referance, where a '$' before the column or row
+// anchors it, like $A1, A$1 or $A$1.
 func parseCellReference(text string, position CellPosition) (Token, string, error) {
-    column_name := strings.ToUpper(text)[0]
-    column := column_name - 'A'
-    row, text, err := parseInt(text[1:])
+    anchors := Anchors{}
+    if len(text) > 0 && text[0] == '$' {
+        anchors.column = true
+        text = text[1:]
+    }
+
+    column, text, err := parseColumnName(text)
     if err != nil {
         return Token{}, text, err
     }
 
-    refPosition := CellPosition { int(row) - 1, int(column) }
     if len(text) > 0 && text[0] == ':' {
-        return parseRange(refPosition, text[1:], position)
+        whole_column := Token {
+            kind: TokenCell,
+            position: CellPosition { 0, column },
+            anchors: Anchors { row: true, column: anchors.column },
+        }
+        return parseRange(whole_column, text[1:], position)
+    }
+
+    if len(text) > 0 && text[0] == '$' {
+        anchors.row = true
+        text = text[1:]
     }
 
-    return Token { kind: TokenCell, position: refPosition }, text, nil
+    row, text, err := parseInt(text)
+    if err != nil {
+        return Token{}, text, err
+    }
+
+    ref := Token {
+        kind: TokenCell,
+        position: CellPosition { int(row) - 1, int(column) },
+        anchors: anchors,
+    }
+    if len(text) > 0 && text[0] == ':' {
+        return parseRange(ref, text[1:], position)
+    }
+
+    return ref, text, nil
 }
 
 func parseNumber(text string) (Token, string, error) {
@@ -223,6 +499,13 @@ func parseNumber(text string) (Token, string, error) {
         i += 1
     }
 
+    if i + 1 < len(text) && text[i] == '.' && isDigit(text[i + 1]) {
+        i += 1
+        for i < len(text) && isDigit(text[i]) {
+            i += 1
+        }
+    }
+
     number, err := strconv.ParseFloat(text[:i], 64)
     if err != nil {
         return Token{}, text, err
@@ -234,6 +517,76 @@ func parseNumber(text string) (Token, string, error) {
     }, text[i:], nil
 }
 
+func parseString(text string) (Token, string, error) {
+    var builder strings.Builder
+    i := 1
+    for i < len(text) && text[i] != '"' {
+        if text[i] == '\\' && i + 1 < len(text) {
+            i += 1
+        }
+
+        builder.WriteByte(text[i])
+        i += 1
+    }
+
+    if i >= len(text) {
+        return Token{}, "", syntaxError(text, "Unterminated string")
+    }
+
+    return Token {
+        kind: TokenString,
+        name: builder.String(),
+    }, text[i+1:], nil
+}
+
+func isHeaderChar(c byte) bool {
+    return isLetter(c) || isDigit(c) || c == '_'
+}
+
+// Reads a column referance by its header text, either '[A + A]' for any
+// header or '@Total' for one that's a single word.
+func parseHeader(text string) (Token, string, error) {
+    var name string
+    if text[0] == '[' {
+        end := strings.IndexByte(text, ']')
+        if end == -1 {
+            return Token{}, "", syntaxError(text, "Unterminated column header")
+        }
+
+        name, text = strings.TrimSpace(text[1:end]), text[end+1:]
+    } else {
+        i := 1
+        for i < len(text) && isHeaderChar(text[i]) {
+            i += 1
+        }
+
+        name, text = text[1:i], text[i:]
+    }
+
+    if len(name) == 0 {
+        return Token{}, text, syntaxError(text, "Expected column header")
+    }
+
+    return Token {
+        kind: TokenHeader,
+        name: name,
+    }, text, nil
+}
+
+// Reads the 'Constants!' from a referance to another table, like
+// Constants!B2.
+func parseTableName(text string) (string, string, bool) {
+    i := 0
+    for i < len(text) && isHeaderChar(text[i]) {
+        i += 1
+    }
+
+    if i == 0 || i + 1 >= len(text) || text[i] != '!' || text[i + 1] == '=' {
+        return "", text, false
+    }
+    return text[:i], text[i+1:], true
+}
+
 func nextToken(text string, position CellPosition) (Token, string, error) {
     text = strings.TrimLeft(text, " ")
     if len(text) == 0 {
@@ -243,40 +596,102 @@ func nextToken(text string, position CellPosition) (Token, string, error) {
     switch c := text[0]
     {
     case c == '+':
-        return Token { kind: TokenAdd }, text[1:], nil
+        return Token { kind: TokenAdd, name: "+" }, text[1:], nil
+    case c == '-':
+        return Token { kind: TokenSubtract, name: "-" }, text[1:], nil
+    case c == '*':
+        if len(text) > 1 && text[1] == '*' {
+            return Token { kind: TokenPower, name: "**" }, text[2:], nil
+        }
+        return Token { kind: TokenMultiply, name: "*" }, text[1:], nil
+    case c == '/':
+        return Token { kind: TokenDivide, name: "/" }, text[1:], nil
+    case c == '%':
+        return Token { kind: TokenModulo, name: "%" }, text[1:], nil
+    case c == '&':
+        return Token { kind: TokenConcat, name: "&" }, text[1:], nil
+    case c == '"':
+        return parseString(text)
     case c == '(':
         return Token { kind: TokenOpenBrace }, text[1:], nil
     case c == ')':
         return Token { kind: TokenCloseBrace }, text[1:], nil
     case c == ',':
         return Token { kind: TokenComma }, text[1:], nil
+    case c == '[' || c == '@':
+        return parseHeader(text)
+    case isRowRange(text):
+        return parseRowRange(text)
     case c == '$':
-        return parseConstantReferance(text, position)
-    case isDirection(c):
+        return parseCellReference(text, position)
+    case isDirection(c) && !(c == 'v' && len(text) > 1 && isLetter(text[1])):
         return parseRelativeCellReferance(text, position)
     case isLetter(c):
+        if table, text, ok := parseTableName(text); ok {
+            token, text, err := parseCellReference(text, position)
+            token.table = table
+            return token, text, err
+        }
+
         if name, text, err := parseName(text); err == nil {
-            return name, text, err
+            return parseKeyword(name), text, err
         } else {
             return parseCellReference(text, position)
         }
     case isDigit(c):
         return parseNumber(text)
     default:
-        return Token {}, text[1:], fmt.Errorf(
+        return Token {}, text[1:], syntaxError(text,
             "Unexpected char '%c'", c)
     }
 }
 
+func parseKeyword(name Token) Token {
+    switch strings.ToLower(name.name) {
+    case "and":
+        return Token { kind: TokenAnd, name: name.name }
+    case "or":
+        return Token { kind: TokenOr, name: name.name }
+    case "not":
+        return Token { kind: TokenNot, name: name.name }
+    default:
+        return name
+    }
+}
+
+var operatorTokens = []Token {
+    { kind: TokenLessEqual, name: "<=" },
+    { kind: TokenGreaterEqual, name: ">=" },
+    { kind: TokenEqual, name: "==" },
+    { kind: TokenNotEqual, name: "!=" },
+    { kind: TokenLess, name: "<" },
+    { kind: TokenGreater, name: ">" },
+    { kind: TokenPower, name: "^" },
+}
+
+// Reads the next token where a binary operator is expected. Here '^', '<'
+// and '>' can't start a cell referance, so they're read as operators instead.
+func nextOperator(text string, position CellPosition) (Token, string, error) {
+    text = strings.TrimLeft(text, " ")
+    for _, operator := range operatorTokens {
+        if strings.HasPrefix(text, operator.name) {
+            return operator, text[len(operator.name):], nil
+        }
+    }
+
+    return nextToken(text, position)
+}
+
 func expect(kind TokenKind, text string, position CellPosition) (string, error) {
+    start := strings.TrimLeft(text, " ")
     token, text, err := nextToken(text, position)
     if err != nil {
         return text, err
     }
 
     if token.kind != kind {
-        return text, fmt.Errorf(
-            "Expected '%d', got '%d' instead",
+        return text, syntaxError(start,
+            "Expected %s, got %s instead",
             kind, token.kind)
     }
 
@@ -303,13 +718,14 @@ func parseFunction(allocator *ExpressionAllocator,
         }
 
         arguments = append(arguments, argument)
+        start := strings.TrimLeft(text, " ")
         token, text, err = nextToken(text, position)
         if token.kind == TokenCloseBrace {
             break
         }
 
         if token.kind != TokenComma {
-            return nil, text, errors.New("Missing comma")
+            return nil, text, syntaxError(start, "Missing comma")
         }
     }
 
@@ -323,6 +739,7 @@ func parseFunction(allocator *ExpressionAllocator,
 func parseTerm(allocator *ExpressionAllocator,
                text string,
                position CellPosition) (*Expression, string, error) {
+    start := strings.TrimLeft(text, " ")
     token, text, err := nextToken(text, position)
     if err != nil {
         return nil, text, err
@@ -330,81 +747,227 @@ func parseTerm(allocator *ExpressionAllocator,
 
     switch token.kind {
     case TokenName:
-        return parseFunction(allocator, token.name, text, position)
+        if strings.HasPrefix(strings.TrimLeft(text, " "), "(") {
+            expression, text, err := parseFunction(allocator, token.name, text, position)
+            if err != nil {
+                return nil, text, err
+            }
+
+            expression.column = len(start)
+            return expression, text, nil
+        }
+
+        expression := allocator.New()
+        expression.kind = ExpressionName
+        expression.name = token.name
+        expression.column = len(start)
+        return expression, text, nil
+    case TokenOpenBrace:
+        expression, text, err := parseExpression(allocator, text, position)
+        if err != nil {
+            return nil, text, err
+        }
+
+        text, err = expect(TokenCloseBrace, text, position)
+        if err != nil {
+            return nil, text, err
+        }
+        return expression, text, nil
+    case TokenSubtract:
+        return parseUnary(allocator, ExpressionNegate, negatePrecedence, text, position)
+    case TokenNot:
+        return parseUnary(allocator, ExpressionNot, notPrecedence, text, position)
     case TokenNumber:
         expression := allocator.New()
         expression.kind = ExpressionNumber
         expression.number = token.number
         return expression, text, nil
-    case TokenCell:
+    case TokenString:
         expression := allocator.New()
-        expression.kind = ExpressionCell
-        expression.position = token.position
+        expression.kind = ExpressionString
+        expression.text = token.name
         return expression, text, nil
-    case TokenConstant:
+    case TokenCell:
         expression := allocator.New()
-        expression.kind = ExpressionConstant
+        expression.kind = ExpressionCell
         expression.position = token.position
+        expression.anchors = token.anchors
+        expression.table = token.table
+        expression.relative = token.relative
         return expression, text, nil
     case TokenRange:
         expression := allocator.New()
         expression.kind = ExpressionRange
         expression.cellRange = token.cellRange
+        expression.table = token.table
+        return expression, text, nil
+    case TokenHeader:
+        expression := allocator.New()
+        expression.kind = ExpressionHeader
+        expression.name = token.name
+        expression.header = true
+        expression.position = position
+        expression.column = len(start)
         return expression, text, nil
-    case TokenAdd:
-        return nil, text, errors.New(
-            "Unexpected '+', expected value")
+    case TokenAdd, TokenMultiply, TokenDivide, TokenModulo, TokenPower,
+         TokenAnd, TokenOr, TokenConcat:
+        return nil, text, syntaxError(start,
+            "Unexpected '%s', expected value", token.name)
+    case TokenCloseBrace, TokenComma:
+        return nil, text, syntaxError(start,
+            "Unexpected %s, expected value", token.kind)
     case TokenEmpty:
-        return nil, text, errors.New(
+        return nil, text, syntaxError(start,
             "Expected value, got nothing instead")
     default:
         panic(0)
     }
 }
 
+// Turns the column of each name, header and function call from how much
+// of the text was left at it into its offset in the text.
+func locateNames(expression *Expression, length int) {
+    if expression == nil {
+        return
+    }
+
+    switch expression.kind {
+    case ExpressionName, ExpressionHeader:
+        expression.column = length - expression.column
+    case ExpressionFunction:
+        expression.column = length - expression.column
+        for _, argument := range expression.arguments {
+            locateNames(argument, length)
+        }
+    default:
+        locateNames(expression.lhs, length)
+        locateNames(expression.rhs, length)
+    }
+}
+
+type BinaryOperator struct {
+    kind ExpressionKind
+    precedence int
+    rightAssociative bool
+}
+
+// Unary minus binds tighter than '*' but looser than '**', so '-2 ** 2'
+// is -4 and '-2 * 3' is -6. 'not' binds looser than any comparison.
+const (
+    notPrecedence = 3
+    negatePrecedence = 8
+)
+
+var binaryOperators = map[TokenKind]BinaryOperator {
+    TokenOr:           { ExpressionOr, 1, false },
+    TokenAnd:          { ExpressionAnd, 2, false },
+    TokenLess:         { ExpressionLess, 4, false },
+    TokenGreater:      { ExpressionGreater, 4, false },
+    TokenLessEqual:    { ExpressionLessEqual, 4, false },
+    TokenGreaterEqual: { ExpressionGreaterEqual, 4, false },
+    TokenEqual:        { ExpressionEqual, 4, false },
+    TokenNotEqual:     { ExpressionNotEqual, 4, false },
+    TokenConcat:       { ExpressionConcat, 5, false },
+    TokenAdd:          { ExpressionAdd, 6, false },
+    TokenSubtract:     { ExpressionSubtract, 6, false },
+    TokenMultiply:     { ExpressionMultiply, 7, false },
+    TokenDivide:       { ExpressionDivide, 7, false },
+    TokenModulo:       { ExpressionModulo, 7, false },
+    TokenPower:        { ExpressionPower, 9, true },
+}
+
+func parseUnary(allocator *ExpressionAllocator,
+                kind ExpressionKind,
+                precedence int,
+                text string,
+                position CellPosition) (*Expression, string, error) {
+    operand, text, err := parseBinary(allocator, text, position, precedence)
+    if err != nil {
+        return nil, text, err
+    }
+
+    expression := allocator.New()
+    expression.kind = kind
+    expression.lhs = operand
+    return expression, text, nil
+}
+
 func parseOperation(allocator *ExpressionAllocator,
                     lhs *Expression,
-                    kind ExpressionKind,
+                    operator BinaryOperator,
                     text string,
                     position CellPosition) (*Expression, string, error) {
-    rhs, text, err := parseExpression(allocator, text, position)
+    precedence := operator.precedence + 1
+    if operator.rightAssociative {
+        precedence = operator.precedence
+    }
+
+    rhs, text, err := parseBinary(allocator, text, position, precedence)
     if err != nil {
         return nil, text, err
     }
 
     expression := allocator.New()
-    expression.kind = kind
+    expression.kind = operator.kind
     expression.lhs = lhs
     expression.rhs = rhs
     return expression, text, nil
 }
 
-func parseExpression(allocator *ExpressionAllocator,
-                     text string,
-                     position CellPosition) (*Expression, string, error) {
+// Parses operators using precedence climbing, only consuming operators
+// that bind at least as tight as min_precedence.
+func parseBinary(allocator *ExpressionAllocator,
+                 text string,
+                 position CellPosition,
+                 min_precedence int) (*Expression, string, error) {
     result, text, err := parseTerm(allocator, text, position)
     if err != nil {
         return nil, text, err
     }
 
-    should_continue := true
-    for should_continue {
-        token, next_text, err := nextToken(text, position)
+    for {
+        token, next_text, err := nextOperator(text, position)
         if err != nil {
             return nil, text, err
         }
 
-        switch token.kind {
-        case TokenAdd:
-            result, text, err = parseOperation(allocator, result, ExpressionAdd, next_text, position)
-            if err != nil {
-                return nil, text, err
-            }
-        default:
-            should_continue = false
+        operator, found := binaryOperators[token.kind]
+        if !found || operator.precedence < min_precedence {
+            break
+        }
+
+        result, text, err = parseOperation(allocator, result, operator, next_text, position)
+        if err != nil {
+            return nil, text, err
         }
     }
 
     return result, text, nil
 }
 
+func parseExpression(allocator *ExpressionAllocator,
+                     text string,
+                     position CellPosition) (*Expression, string, error) {
+    return parseBinary(allocator, text, position, 0)
+}
+
+// Parses a whole cell's expression, so anything after it is an error.
+func parseCellExpression(allocator *ExpressionAllocator,
+                         text string,
+                         position CellPosition) (*Expression, string, error) {
+    expression, text, err := parseExpression(allocator, text, position)
+    if err != nil {
+        return nil, text, err
+    }
+
+    rest := strings.TrimLeft(text, " ")
+    if len(rest) > 0 {
+        token, _, err := nextToken(rest, position)
+        if err != nil {
+            return nil, rest, err
+        }
+        return nil, rest, syntaxError(rest, "Unexpected %s", token.kind)
+    }
+
+    return expression, text, nil
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+)
+
+var operatorNames = map[ExpressionKind]string {
+    ExpressionAdd: "+",
+    ExpressionSubtract: "-",
+    ExpressionMultiply: "*",
+    ExpressionDivide: "/",
+    ExpressionModulo: "%",
+    ExpressionPower: "^",
+    ExpressionLess: "<",
+    ExpressionGreater: ">",
+    ExpressionLessEqual: "<=",
+    ExpressionGreaterEqual: ">=",
+    ExpressionEqual: "==",
+    ExpressionNotEqual: "!=",
+    ExpressionAnd: "and",
+    ExpressionOr: "or",
+    ExpressionConcat: "&",
+}
+
+// Binds tighter than any operator, so it never needs brackets.
+const termPrecedence = 100
+
+func binaryOperatorFor(kind ExpressionKind) (BinaryOperator, bool) {
+    for _, operator := range binaryOperators {
+        if operator.kind == kind {
+            return operator, true
+        }
+    }
+    return BinaryOperator{}, false
+}
+
+func expressionPrecedence(expression *Expression) int {
+    switch expression.kind {
+    case ExpressionNegate:
+        return negatePrecedence
+    case ExpressionNot:
+        return notPrecedence
+    }
+
+    if operator, found := binaryOperatorFor(expression.kind); found {
+        return operator.precedence
+    }
+    return termPrecedence
+}
+
+func anchorPrefix(anchored bool) string {
+    if anchored {
+        return "$"
+    }
+    return ""
+}
+
+// Formats a referance relative to the cell it's in, like ^2 or <.
+func formatRelative(position CellPosition, at CellPosition) string {
+    direction, offset := "^", 0
+    switch rows, columns := position.row - at.row, position.column - at.column; {
+    case rows < 0: direction, offset = "^", -rows
+    case rows > 0: direction, offset = "v", rows
+    case columns < 0: direction, offset = "<", -columns
+    case columns > 0: direction, offset = ">", columns
+    }
+
+    if offset == 1 {
+        return direction
+    }
+    return direction + strconv.Itoa(offset)
+}
+
+func formatReferance(position CellPosition, anchors Anchors) string {
+    return anchorPrefix(anchors.column) + formatColumnName(position.column) +
+        anchorPrefix(anchors.row) + strconv.Itoa(position.row + 1)
+}
+
+func formatRange(r Range, at CellPosition) string {
+    if r.openColumn {
+        return anchorPrefix(r.startAnchors.row) + strconv.Itoa(r.start.row + 1) + ":" +
+            anchorPrefix(r.endAnchors.row) + strconv.Itoa(r.end.row + 1)
+    }
+
+    start := formatReferance(r.start, r.startAnchors)
+    if r.openRow && r.start.row == 0 && r.startAnchors.row {
+        start = anchorPrefix(r.startAnchors.column) + formatColumnName(r.start.column)
+    }
+
+    end := anchorPrefix(r.endAnchors.column) + formatColumnName(r.end.column)
+    switch {
+    case r.relativeEnd:
+        end = formatRelative(r.end, at)
+    case r.toSeporator:
+        end += "_"
+    case !r.openRow:
+        end += anchorPrefix(r.endAnchors.row) + strconv.Itoa(r.end.row + 1)
+    }
+
+    return start + ":" + end
+}
+
+func formatHeader(name string) string {
+    for i := 0; i < len(name); i++ {
+        if !isHeaderChar(name[i]) {
+            return "[" + name + "]"
+        }
+    }
+    return "@" + name
+}
+
+var stringEscaper = strings.NewReplacer("\\", "\\\\", "\"", "\\\"")
+
+// Writes the operand in brackets if it binds looser than the operator
+// it's used in.
+func formatOperand(operand *Expression, precedence int, at CellPosition) string {
+    text := formatExpression(operand, at)
+    if expressionPrecedence(operand) < precedence {
+        return "(" + text + ")"
+    }
+    return text
+}
+
+// Formats an unresolved expression back into source, with only the
+// brackets it needs. Relative referances are written relative to at.
+func formatExpression(expression *Expression, at CellPosition) string {
+    table := ""
+    if expression.table != "" {
+        table = expression.table + "!"
+    }
+
+    switch expression.kind {
+    case ExpressionNumber:
+        return strconv.FormatFloat(expression.number, 'f', -1, 64)
+    case ExpressionString:
+        return "\"" + stringEscaper.Replace(expression.text) + "\""
+    case ExpressionCell:
+        if expression.relative {
+            return formatRelative(expression.position, at)
+        }
+        return table + formatReferance(expression.position, expression.anchors)
+    case ExpressionRange:
+        return table + formatRange(expression.cellRange, at)
+    case ExpressionName:
+        return expression.name
+    case ExpressionHeader:
+        return formatHeader(expression.name)
+    case ExpressionFunction:
+        arguments := make([]string, len(expression.arguments))
+        for i, argument := range expression.arguments {
+            arguments[i] = formatExpression(argument, at)
+        }
+        return expression.function + "(" + strings.Join(arguments, ", ") + ")"
+    case ExpressionNegate:
+        return "-" + formatOperand(expression.lhs, negatePrecedence, at)
+    case ExpressionNot:
+        return "not " + formatOperand(expression.lhs, notPrecedence, at)
+    }
+
+    operator, found := binaryOperatorFor(expression.kind)
+    if !found {
+        panic(0)
+    }
+
+    lhs, rhs := operator.precedence, operator.precedence + 1
+    if operator.rightAssociative {
+        lhs, rhs = rhs, lhs
+    }
+    return formatOperand(expression.lhs, lhs, at) + " " +
+        operatorNames[expression.kind] + " " +
+        formatOperand(expression.rhs, rhs, at)
+}
+
+func formatDirection(direction Direction) string {
+    switch direction {
+    case DirectionUp: return "^"
+    case DirectionRight: return ">"
+    case DirectionDown: return "v"
+    case DirectionLeft: return "<"
+    default: panic(0)
+    }
+}
+
+// Formats a cell's source text in canonical form, keeping its number
+// format. Cells that don't parse are kept as they were written.
+func formatCell(allocator *ExpressionAllocator, text string, position CellPosition) string {
+    cell := parseCell(allocator, text, position)
+    if cell.format == nil || cell.kind == CellError || cell.kind == CellEmpty {
+        return formatCellContent(cell, text, position)
+    }
+
+    body, _, _ := cutNumberFormat(text)
+    return formatCellContent(cell, body, position) + " ;" + cell.format.spec
+}
+
+// Text and numbers are kept exactly as they were written, so 1.50 or
+// 02134 don't change.
+func formatCellContent(cell Cell, text string, position CellPosition) string {
+    switch cell.kind {
+    case CellExpression:
+        return "=" + formatExpression(cell.expression, position)
+    case CellClone:
+        if cell.offset == 1 {
+            return ":" + formatDirection(cell.direction)
+        }
+        return ":" + formatDirection(cell.direction) + strconv.Itoa(cell.offset)
+    case CellSeporator:
+        return "__"
+    default:
+        return text
+    }
+}
+
+// Writes one block's source, with every column padded to the same width.
+func writeSourceBlock(output *bytes.Buffer, source *blockSource) {
+    allocator := newExpressionAllocator()
+    lines := make([][]string, len(source.records))
+    widths := make([]int, 0)
+
+    row := 0
+    for i, record := range source.records {
+        if _, ok := source.directive(record); ok {
+            continue
+        }
+        if line, ok := source.cloneLine(record); ok {
+            if count, err := strconv.ParseUint(strings.TrimSpace(line[3:]), 10, 32); err == nil {
+                row += int(count)
+            }
+            continue
+        }
+
+        end := len(record)
+        for end > 0 && record[end - 1] == "" {
+            end -= 1
+        }
+
+        cells := make([]string, end)
+        for column := range cells {
+            cells[column] = formatCell(&allocator, record[column], CellPosition { row, column })
+            if column >= len(widths) {
+                widths = append(widths, 0)
+            }
+            if width := displayWidth(cells[column]); width > widths[column] {
+                widths[column] = width
+            }
+        }
+
+        lines[i] = cells
+        row += 1
+    }
+
+    for i, record := range source.records {
+        if line, ok := source.directive(record); ok {
+            output.WriteString(line + "\n")
+            continue
+        }
+        if line, ok := source.cloneLine(record); ok {
+            count, err := strconv.ParseUint(strings.TrimSpace(line[3:]), 10, 32)
+            if err == nil {
+                line = "... " + strconv.FormatUint(count, 10)
+            }
+            output.WriteString(line + "\n")
+            continue
+        }
+
+        cells := lines[i]
+        for column, text := range cells {
+            if text == "__" {
+                text = strings.Repeat("_", maxInt(widths[column], 2))
+            }
+
+            output.WriteString(text)
+            if column != len(cells) - 1 {
+                padding := widths[column] - displayWidth(text)
+                output.WriteString(strings.Repeat(" ", maxInt(padding, 0)))
+                output.WriteString(" | ")
+            }
+        }
+        output.WriteString("\n")
+    }
+}
+
+func writeSources(output *bytes.Buffer, sources []blockSource) {
+    first := true
+    for i := range sources {
+        if sources[i].included {
+            continue
+        }
+
+        if !first {
+            output.WriteString("\n")
+        }
+        if sources[i].name != "" {
+            output.WriteString("[" + sources[i].name + "]\n")
+        }
+
+        writeSourceBlock(output, &sources[i])
+        first = false
+    }
+}
+
+// Rewrites .cell source in canonical form, with formulas, clones and
+// '...' lines kept as they are and columns lined up.
+func FormatSource(input []byte) ([]byte, error) {
+    sources, err := splitBlocks(string(input))
+    if err != nil {
+        return nil, err
+    }
+
+    var output bytes.Buffer
+    writeSources(&output, sources)
+    return output.Bytes(), nil
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+)
+
+type ArgumentKind int
+const (
+    ArgumentValue ArgumentKind = iota
+    ArgumentRange
+
+    // Either a range or a single value, for functions that flatten
+    // all their arguments into one list of numbers.
+    ArgumentAny
+)
+
+func (kind ArgumentKind) String() string {
+    switch kind {
+    case ArgumentValue: return "a value"
+    case ArgumentRange: return "a range"
+    case ArgumentAny: return "a range or value"
+    default: panic(0)
+    }
+}
+
+func (kind ArgumentKind) Accepts(argument *Expression) bool {
+    switch kind {
+    case ArgumentValue: return argument.kind != ExpressionRange
+    case ArgumentRange: return argument.kind == ExpressionRange
+    case ArgumentAny: return true
+    default: panic(0)
+    }
+}
+
+// Implementations read their arguments through the table, with
+// EvaluateArgument, EvaluateNumberArgument, EvaluateStringArgument,
+// EvaluateRangeArgument or ArgumentNumbers, passing the CellPosition
+// they were given along.
+type FunctionImplementation func(*Table, []*Expression, CellPosition) (Value, error)
+
+type Function struct {
+    function FunctionImplementation
+    arguments []ArgumentKind
+
+    // The last argument kind can be repeated any number of times.
+    variadic bool
+}
+
+func (function Function) ArgumentKind(index int) ArgumentKind {
+    if index >= len(function.arguments) {
+        return function.arguments[len(function.arguments)-1]
+    }
+    return function.arguments[index]
+}
+
+// Wraps an error caused by one of a function's arguments, so it can be
+// reported along with the function's name.
+type ArgumentError struct {
+    index int
+    err error
+}
+
+func (err ArgumentError) Error() string {
+    return fmt.Sprintf("Argument %d: %s", err.index + 1, err.err)
+}
+
+// How every argument error is made, with the argument's index from 0.
+func NewArgumentError(index int, err error) ArgumentError {
+    return ArgumentError { index, err }
+}
+
+func (table *Table) EvaluateArgument(arguments []*Expression,
+                                     index int,
+                                     shiftOffset CellPosition) (Value, error) {
+    value, err := table.EvaluateExpression(arguments[index], shiftOffset)
+    if err != nil {
+        return Value{}, NewArgumentError(index, err)
+    }
+
+    return value, nil
+}
+
+func (table *Table) EvaluateNumberArgument(arguments []*Expression,
+                                           index int,
+                                           shiftOffset CellPosition) (float64, error) {
+    value, err := table.EvaluateArgument(arguments, index, shiftOffset)
+    if err != nil {
+        return -1, err
+    }
+
+    number, err := value.AsNumber()
+    if err != nil {
+        return -1, NewArgumentError(index, err)
+    }
+
+    return number, nil
+}
+
+func (table *Table) EvaluateStringArgument(arguments []*Expression,
+                                           index int,
+                                           shiftOffset CellPosition) (string, error) {
+    value, err := table.EvaluateArgument(arguments, index, shiftOffset)
+    if err != nil {
+        return "", err
+    }
+
+    return value.String(), nil
+}
+
+// The value of each cell in a range argument, in row order. Any other
+// argument gives just its own value.
+func (table *Table) EvaluateRangeArgument(arguments []*Expression,
+                                          index int,
+                                          shiftOffset CellPosition) ([]Value, error) {
+    argument := arguments[index]
+    if argument.kind != ExpressionRange {
+        value, err := table.EvaluateArgument(arguments, index, shiftOffset)
+        if err != nil {
+            return nil, err
+        }
+        return []Value { value }, nil
+    }
+
+    a, err := table.resolveRange(argument.cellRange, shiftOffset)
+    if err != nil {
+        return nil, NewArgumentError(index, err)
+    }
+
+    values := make([]Value, 0)
+    for row := a.start.row; row <= a.end.row; row++ {
+        for column := a.start.column; column <= a.end.column; column++ {
+            value := table.CellAt(CellPosition { row, column }).Value()
+            if value.kind == ValueError {
+                return nil, NewArgumentError(index, value.err)
+            }
+            values = append(values, value)
+        }
+    }
+
+    return values, nil
+}
+
+type FunctionRegistry struct {
+    functions map[string]Function
+}
+
+func NewFunctionRegistry() *FunctionRegistry {
+    registry := &FunctionRegistry {
+        functions: make(map[string]Function),
+    }
+
+    registerBuiltinFunctions(registry)
+    return registry
+}
+
+// Functions registered here are available to every table read afterwards.
+var DefaultFunctions = NewFunctionRegistry()
+
+func (registry *FunctionRegistry) Register(name string,
+                                           variadic bool,
+                                           arguments []ArgumentKind,
+                                           function FunctionImplementation) error {
+    if len(name) == 0 {
+        return errors.New("Function name cannot be empty")
+    }
+    for i := 0; i < len(name); i++ {
+        if !isLetter(name[i]) {
+            return fmt.Errorf("Invalid function name '%s'", name)
+        }
+    }
+    if parseKeyword(Token { kind: TokenName, name: name }).kind != TokenName {
+        return fmt.Errorf("Function name '%s' is a keyword", name)
+    }
+    if variadic && len(arguments) == 0 {
+        return fmt.Errorf("Variadic function '%s' needs an argument kind", name)
+    }
+
+    key := strings.ToLower(name)
+    if _, found := registry.functions[key]; found {
+        return fmt.Errorf("Function '%s' is already registered", name)
+    }
+
+    registry.functions[key] = Function {
+        function: function,
+        arguments: arguments,
+        variadic: variadic,
+    }
+    return nil
+}
+
+func (registry *FunctionRegistry) Lookup(name string) (Function, bool) {
+    function, found := registry.functions[strings.ToLower(name)]
+    return function, found
+}
+
+func (registry *FunctionRegistry) mustRegister(name string,
+                                               variadic bool,
+                                               arguments []ArgumentKind,
+                                               function FunctionImplementation) {
+    if err := registry.Register(name, variadic, arguments, function); err != nil {
+        panic(err)
+    }
+}
+
+func registerBuiltinFunctions(registry *FunctionRegistry) {
+    registry.mustRegister("sqrt", false, []ArgumentKind { ArgumentValue }, sqrt)
+    registry.mustRegister("if", false, []ArgumentKind {
+        ArgumentValue, ArgumentValue, ArgumentValue }, if_function)
+
+    values := []ArgumentKind { ArgumentAny }
+    registry.mustRegister("sum", true, values, sum)
+    registry.mustRegister("avg", true, values, average)
+    registry.mustRegister("average", true, values, average)
+    registry.mustRegister("min", true, values, minimum)
+    registry.mustRegister("max", true, values, maximum)
+    registry.mustRegister("count", true, values, count)
+    registry.mustRegister("counta", true, values, counta)
+    registry.mustRegister("median", true, values, median)
+    registry.mustRegister("mode", true, values, mode)
+    registry.mustRegister("stdev", true, values, stdev)
+    registry.mustRegister("var", true, values, variance)
+    registry.mustRegister("product", true, values, product)
+    registry.mustRegister("percentile", false, []ArgumentKind {
+        ArgumentAny, ArgumentValue }, percentile)
+
+    registry.mustRegister("len", false, []ArgumentKind { ArgumentValue }, length)
+    registry.mustRegister("upper", false, []ArgumentKind { ArgumentValue }, upper)
+    registry.mustRegister("lower", false, []ArgumentKind { ArgumentValue }, lower)
+    registry.mustRegister("trim", false, []ArgumentKind { ArgumentValue }, trim)
+    registry.mustRegister("concat", true, values, concat)
+    registry.mustRegister("left", false, []ArgumentKind {
+        ArgumentValue, ArgumentValue }, left)
+    registry.mustRegister("right", false, []ArgumentKind {
+        ArgumentValue, ArgumentValue }, right)
+    registry.mustRegister("mid", false, []ArgumentKind {
+        ArgumentValue, ArgumentValue, ArgumentValue }, mid)
+}
+
+func sqrt(table *Table,
+          arguments []*Expression,
+          shiftOffset CellPosition) (Value, error) {
+    value, err := table.EvaluateNumberArgument(arguments, 0, shiftOffset)
+    if err != nil {
+        return Value{}, err
+    }
+    if value < 0 {
+        return Value{}, fmt.Errorf(
+            "Cannot take the square root of %s",
+            formatCellNumber(value))
+    }
+
+    return NumberValue(math.Sqrt(value)), nil
+}
+
+// Only the taken branch is evaluated, so an error in the other one
+// doesn't end up in the cell.
+func if_function(table *Table,
+                 arguments []*Expression,
+                 shiftOffset CellPosition) (Value, error) {
+    value, err := table.EvaluateArgument(arguments, 0, shiftOffset)
+    if err != nil {
+        return Value{}, err
+    }
+
+    condition, err := value.AsBoolean()
+    if err != nil {
+        return Value{}, NewArgumentError(0, err)
+    }
+
+    if condition {
+        return table.EvaluateArgument(arguments, 1, shiftOffset)
+    }
+    return table.EvaluateArgument(arguments, 2, shiftOffset)
+}
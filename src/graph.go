@@ -0,0 +1,483 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Edges are stored flat, with a cell's edges starting at offsets[index].
+// Cells whose edges change afterwards are kept in edited instead.
+type EdgeList struct {
+    offsets []int32
+    edges []int32
+    edited map[int32][]int32
+}
+
+func (list *EdgeList) Get(index int) []int32 {
+    if edges, found := list.edited[int32(index)]; found {
+        return edges
+    }
+    return list.edges[list.offsets[index]:list.offsets[index + 1]]
+}
+
+func (list *EdgeList) Set(index int, edges []int32) {
+    if list.edited == nil {
+        list.edited = make(map[int32][]int32)
+    }
+    list.edited[int32(index)] = edges
+}
+
+func (list *EdgeList) Add(index int, edge int32) {
+    edges := list.Get(index)
+    list.Set(index, append(edges[:len(edges):len(edges)], edge))
+}
+
+func (list *EdgeList) Remove(index int, edge int32) {
+    edges := make([]int32, 0, len(list.Get(index)))
+    for _, other := range list.Get(index) {
+        if other != edge {
+            edges = append(edges, other)
+        }
+    }
+    list.Set(index, edges)
+}
+
+// Flips every edge around, so it points from target to source instead.
+func (list *EdgeList) Reverse(count int) EdgeList {
+    reverse := EdgeList {
+        offsets: make([]int32, count + 1),
+    }
+
+    for index := 0; index < count; index++ {
+        for _, edge := range list.Get(index) {
+            reverse.offsets[edge + 1] += 1
+        }
+    }
+    for index := 0; index < count; index++ {
+        reverse.offsets[index + 1] += reverse.offsets[index]
+    }
+
+    reverse.edges = make([]int32, reverse.offsets[count])
+    next := append([]int32{}, reverse.offsets[:count]...)
+    for index := 0; index < count; index++ {
+        for _, edge := range list.Get(index) {
+            reverse.edges[next[edge]] = int32(index)
+            next[edge] += 1
+        }
+    }
+
+    return reverse
+}
+
+// Tracks which cells each cell reads from, and the reverse, which cells
+// read from it.
+type DependencyGraph struct {
+    dependencies EdgeList
+    dependents EdgeList
+}
+
+func (graph *DependencyGraph) Dependencies(index int) []int32 {
+    return graph.dependencies.Get(index)
+}
+
+func (graph *DependencyGraph) Dependents(index int) []int32 {
+    return graph.dependents.Get(index)
+}
+
+func (graph *DependencyGraph) SetDependencies(index int, dependencies []int32) {
+    for _, dependency := range graph.dependencies.Get(index) {
+        graph.dependents.Remove(int(dependency), int32(index))
+    }
+    for _, dependency := range dependencies {
+        graph.dependents.Add(int(dependency), int32(index))
+    }
+
+    graph.dependencies.Set(index, dependencies)
+}
+
+func (table *Table) indexOf(position CellPosition) int {
+    return position.row * table.columns + position.column
+}
+
+func (table *Table) positionOf(index int) CellPosition {
+    return CellPosition { index / table.columns, index % table.columns }
+}
+
+func (table *Table) cycleError(path []CellPosition) error {
+    names := make([]string, len(path))
+    for i, position := range path {
+        names[i] = table.addressOf(position)
+    }
+
+    return fmt.Errorf("Circular reference: %s", strings.Join(names, " -> "))
+}
+
+// The path around a cycle, starting and ending at one of its cells.
+func cyclePath(cycle []CellPosition, start int) []CellPosition {
+    return append(append([]CellPosition{}, cycle[start:]...), cycle[:start+1]...)
+}
+
+// Marks every cell in a cycle with the shortest path from itself, around
+// the cycle and back.
+func (table *Table) markCycle(graph *DependencyGraph, component []int) {
+    members := make(map[int]bool, len(component))
+    for _, index := range component {
+        members[index] = true
+    }
+
+    for _, index := range component {
+        path := table.shortestCycle(graph, index, members)
+        cell := &table.content[index]
+        cell.value = ErrorValue(table.cycleError(path))
+        cell.evaluationState = EvaluationDone
+    }
+}
+
+// Searches breadth first from a cell, through the cells in its component,
+// for the shortest way back to it.
+func (table *Table) shortestCycle(graph *DependencyGraph,
+                                  first int,
+                                  members map[int]bool) []CellPosition {
+    previous := map[int]int { first: -1 }
+    queue := []int { first }
+    for len(queue) > 0 {
+        index := queue[0]
+        queue = queue[1:]
+
+        for _, dependency := range graph.Dependencies(index) {
+            next := int(dependency)
+            if next == first {
+                path := []CellPosition { table.positionOf(first) }
+                for ; index != -1; index = previous[index] {
+                    path = append(path, table.positionOf(index))
+                }
+
+                for i, j := 0, len(path) - 1; i < j; i, j = i + 1, j - 1 {
+                    path[i], path[j] = path[j], path[i]
+                }
+                return path
+            }
+
+            if _, seen := previous[next]; !seen && members[next] {
+                previous[next] = index
+                queue = append(queue, next)
+            }
+        }
+    }
+
+    return []CellPosition { table.positionOf(first) }
+}
+
+// Turns each clone in a cycle of clones into an error, keeping where it
+// was cloned from so it can be copied again once the cycle is broken.
+func (table *Table) markCloneCycle(cycle []CellPosition) {
+    for i, position := range cycle {
+        cell := table.CellAt(position)
+        *cell = Cell {
+            kind: CellError,
+            value: ErrorValue(table.cycleError(cyclePath(cycle, i))),
+            cloned: true,
+            direction: cell.direction,
+            offset: cell.offset,
+        }
+    }
+}
+
+// Follows a clone that's just been set back through the copies already
+// made of other clones. Gives the chain if it comes back around to the
+// same cell.
+func (table *Table) findCloneCycle(position CellPosition) []CellPosition {
+    chain := []CellPosition { position }
+    seen := map[CellPosition]bool { position: true }
+    for {
+        last := chain[len(chain) - 1]
+        cell := table.CellAt(last)
+        if cell.kind != CellClone && !cell.cloned {
+            return nil
+        }
+
+        source := last.Offset(cell.direction, cell.offset)
+        if source == position {
+            return chain
+        }
+        if seen[source] || !table.inBlock(source, table.blockAt(last.row)) {
+            return nil
+        }
+
+        seen[source] = true
+        chain = append(chain, source)
+    }
+}
+
+// Follows a chain of clones back to the first cell that isn't one, then
+// copies it forwards along the chain.
+func (table *Table) resolveClone(position CellPosition) {
+    table.CellAt(position).evaluationState = EvaluationInProgress
+    chain := []CellPosition { position }
+    for {
+        last := chain[len(chain) - 1]
+        cell := table.CellAt(last)
+        clonePosition := last.Offset(cell.direction, cell.offset)
+        source := table.cloneSource(last, cell.direction, cell.offset)
+        if source.kind != CellClone {
+            break
+        }
+
+        if source.evaluationState == EvaluationInProgress {
+            start := len(chain) - 1
+            for chain[start] != clonePosition {
+                start -= 1
+            }
+
+            table.markCloneCycle(chain[start:])
+            chain = chain[:start]
+            break
+        }
+
+        source.evaluationState = EvaluationInProgress
+        chain = append(chain, clonePosition)
+    }
+
+    for i := len(chain) - 1; i >= 0; i-- {
+        table.copyClone(table.CellAt(chain[i]), chain[i])
+    }
+}
+
+// Keeps where the clone came from, so it can be copied again if the
+// source changes, and its own number format if it has one.
+func (table *Table) copyClone(cell *Cell, position CellPosition) {
+    direction, offset, format := cell.direction, cell.offset, cell.format
+    *cell = *table.cloneSource(position, direction, offset)
+    cell.Offset(direction, offset)
+    cell.cloned, cell.direction, cell.offset = true, direction, offset
+    if format != nil {
+        cell.format = format
+    }
+}
+
+// Replaces every clone with a shifted copy of the cell it points at, so
+// only expressions are left to evaluate. Returns the clones of each cell.
+func (table *Table) resolveClones() EdgeList {
+    for index := range table.content {
+        if table.content[index].kind == CellClone {
+            table.resolveClone(table.positionOf(index))
+        }
+    }
+
+    sources := EdgeList {
+        offsets: make([]int32, len(table.content) + 1),
+        edges: make([]int32, 0),
+    }
+    for index := range table.content {
+        if cell := &table.content[index]; cell.cloned {
+            position := table.positionOf(index)
+            source := position.Offset(cell.direction, cell.offset)
+            sources.edges = table.appendDependency(
+                sources.edges, source, table.blockAt(position.row))
+        }
+        sources.offsets[index + 1] = int32(len(sources.edges))
+    }
+
+    return sources.Reverse(len(table.content))
+}
+
+// Only adds the dependency if it's inside the block being referenced, as
+// anything outside it is an error when evaluated.
+func (table *Table) appendDependency(edges []int32, position CellPosition, block int) []int32 {
+    if block == -1 || !table.inBlock(position, block) {
+        return edges
+    }
+
+    return append(edges, int32(table.indexOf(position)))
+}
+
+func (table *Table) appendDependencies(edges []int32,
+                                       expression *Expression,
+                                       shiftOffset CellPosition) []int32 {
+    if expression == nil {
+        return edges
+    }
+
+    switch expression.kind {
+    case ExpressionCell:
+        return table.appendDependency(edges,
+            expression.anchors.Shift(expression.position, shiftOffset), expression.block)
+    case ExpressionRange:
+        a, err := table.resolveRange(expression.cellRange, shiftOffset)
+        if err != nil {
+            return edges
+        }
+
+        for row := a.start.row; row <= a.end.row; row++ {
+            for column := a.start.column; column <= a.end.column; column++ {
+                edges = table.appendDependency(edges, CellPosition { row, column }, a.block)
+            }
+        }
+        return edges
+    case ExpressionFunction:
+        for _, argument := range expression.arguments {
+            edges = table.appendDependencies(edges, argument, shiftOffset)
+        }
+        return edges
+    case ExpressionName, ExpressionHeader:
+        return edges
+    default:
+        edges = table.appendDependencies(edges, expression.lhs, shiftOffset)
+        return table.appendDependencies(edges, expression.rhs, shiftOffset)
+    }
+}
+
+func (table *Table) cellDependencies(edges []int32, cell *Cell) []int32 {
+    if cell.kind != CellExpression {
+        return edges
+    }
+    return table.appendDependencies(edges, cell.expression, cell.expressionOffset)
+}
+
+func (table *Table) buildDependencyGraph() DependencyGraph {
+    dependencies := EdgeList {
+        offsets: make([]int32, len(table.content) + 1),
+        edges: make([]int32, 0, len(table.content)),
+    }
+
+    for index := range table.content {
+        dependencies.edges = table.cellDependencies(
+            dependencies.edges, &table.content[index])
+        dependencies.offsets[index + 1] = int32(len(dependencies.edges))
+    }
+
+    return DependencyGraph {
+        dependencies: dependencies,
+        dependents: dependencies.Reverse(len(table.content)),
+    }
+}
+
+type visitState uint8
+const (
+    visitPending visitState = iota
+    visitInProgress
+    visitDone
+)
+
+type visitFrame struct {
+    index int
+    next int
+
+    // When the cell was first visited, counting from 1.
+    visited int32
+}
+
+// Orders cells so each one comes after everything it depends on. States
+// start as pending for the cells still to be ordered, and done for any to
+// be left alone.
+type cellOrder struct {
+    graph *DependencyGraph
+    states []visitState
+
+    // The earliest visited cell each cell is known to reach, which is its
+    // own visit while it's still thought to start a component.
+    low []int32
+    visits int32
+
+    // Cells visited whose component hasn't been closed yet.
+    open []int
+    order []int
+}
+
+func newCellOrder(graph *DependencyGraph, states []visitState) cellOrder {
+    return cellOrder {
+        graph: graph,
+        states: states,
+        low: make([]int32, len(states)),
+        order: make([]int, 0),
+    }
+}
+
+func (search *cellOrder) start(index int) visitFrame {
+    search.visits += 1
+    search.states[index] = visitInProgress
+    search.low[index] = search.visits
+    search.open = append(search.open, index)
+    return visitFrame { index, 0, search.visits }
+}
+
+// Finds the strongly connected components of the cells reachable from the
+// root with Tarjan's algorithm, using an explicit stack so long chains of
+// references don't grow the native one. Each component is ordered after
+// everything it depends on, and every cell in one that's a cycle is
+// marked as an error.
+func (table *Table) visit(search *cellOrder, root int) {
+    stack := []visitFrame { search.start(root) }
+    for len(stack) > 0 {
+        top := &stack[len(stack) - 1]
+        dependencies := search.graph.Dependencies(top.index)
+        if top.next < len(dependencies) {
+            dependency := int(dependencies[top.next])
+            top.next += 1
+
+            switch search.states[dependency] {
+            case visitPending:
+                stack = append(stack, search.start(dependency))
+            case visitInProgress:
+                if search.low[dependency] < search.low[top.index] {
+                    search.low[top.index] = search.low[dependency]
+                }
+            }
+            continue
+        }
+
+        frame := *top
+        stack = stack[:len(stack) - 1]
+        if len(stack) > 0 {
+            parent := stack[len(stack) - 1].index
+            if search.low[frame.index] < search.low[parent] {
+                search.low[parent] = search.low[frame.index]
+            }
+        }
+        if search.low[frame.index] == frame.visited {
+            table.closeComponent(search, frame.index)
+        }
+    }
+}
+
+// Takes the component starting at a cell off the open cells, and adds it
+// to the order.
+func (table *Table) closeComponent(search *cellOrder, first int) {
+    start := len(search.open) - 1
+    for search.open[start] != first {
+        start -= 1
+    }
+
+    component := search.open[start:]
+    search.open = search.open[:start]
+    for _, index := range component {
+        search.states[index] = visitDone
+    }
+    if len(component) > 1 || dependsOn(search.graph, first, first) {
+        table.markCycle(search.graph, component)
+    }
+    search.order = append(search.order, component...)
+}
+
+func dependsOn(graph *DependencyGraph, index int, dependency int) bool {
+    for _, other := range graph.Dependencies(index) {
+        if int(other) == dependency {
+            return true
+        }
+    }
+    return false
+}
+
+// Orders cells so each one comes after everything it depends on, only
+// visiting cells that are still pending. Cells in a cycle are marked as
+// errors along the way.
+func (table *Table) topologicalOrder(graph *DependencyGraph, states []visitState) []int {
+    search := newCellOrder(graph, states)
+    for index := range table.content {
+        if states[index] == visitPending && table.content[index].kind == CellExpression {
+            table.visit(&search, index)
+        }
+    }
+
+    return search.order
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func isInclude(line string) bool {
+    return strings.HasPrefix(line, "#include ")
+}
+
+// Reads the path from a line like '#include "rates.cell"', relative to
+// the directory of the file it's in.
+func parseInclude(line string, directory string) (string, error) {
+    text := strings.TrimSpace(strings.TrimPrefix(line, "#include"))
+    if len(text) < 2 || text[0] != '"' || text[len(text) - 1] != '"' {
+        return "", fmt.Errorf("Expected quoted file name in '%s'", line)
+    }
+
+    path := text[1:len(text) - 1]
+    if !filepath.IsAbs(path) {
+        path = filepath.Join(directory, path)
+    }
+    return filepath.Abs(path)
+}
+
+// The unnamed block of an included file is named after the file, so
+// rates.cell can be referenced as rates!B2. Characters that can't be in a
+// table name are replaced with '_', so tax-rates.cell becomes tax_rates.
+func includedBlockName(path string) (string, error) {
+    file := filepath.Base(path)
+    name := []byte(strings.TrimSuffix(file, filepath.Ext(file)))
+    for i := range name {
+        if !isHeaderChar(name[i]) {
+            name[i] = '_'
+        }
+    }
+
+    if len(name) == 0 || !isLetter(name[0]) {
+        return "", fmt.Errorf(
+            "Cannot name a table after '%s', give it a [Name] header", file)
+    }
+    return string(name), nil
+}
+
+type includeReader struct {
+    stack []string
+    seen map[string]bool
+    sources []blockSource
+}
+
+func (reader *includeReader) includeCycle(path string) error {
+    start := 0
+    for reader.stack[start] != path {
+        start += 1
+    }
+
+    names := make([]string, 0, len(reader.stack) - start + 1)
+    for _, file := range append(reader.stack[start:], path) {
+        names = append(names, filepath.Base(file))
+    }
+    return fmt.Errorf("Include cycle: %s", strings.Join(names, " -> "))
+}
+
+// Reads a file's blocks after those of everything it includes. A file
+// included more than once is only read the first time.
+func (reader *includeReader) read(path string, included bool) error {
+    for _, file := range reader.stack {
+        if file == path {
+            return reader.includeCycle(path)
+        }
+    }
+    if reader.seen[path] {
+        return nil
+    }
+
+    input, err := os.ReadFile(path)
+    if err != nil {
+        return err
+    }
+
+    sources, err := splitBlocks(string(input))
+    if err != nil {
+        return fmt.Errorf("%s: %s", filepath.Base(path), err)
+    }
+
+    reader.stack = append(reader.stack, path)
+    for _, source := range sources {
+        for i, record := range source.records {
+            line, ok := source.directive(record)
+            if !ok || !isInclude(line) {
+                continue
+            }
+
+            if err := reader.readInclude(line, path); err != nil {
+                if _, ok := err.(Diagnostic); ok {
+                    return err
+                }
+                return Diagnostic {
+                    file: displayPath(path),
+                    position: source.positions[i][0],
+                    message: err.Error(),
+                }
+            }
+        }
+    }
+    reader.stack = reader.stack[:len(reader.stack) - 1]
+    reader.seen[path] = true
+
+    for _, source := range sources {
+        source.file = displayPath(path)
+        if included && source.name == "" && source.started {
+            name, err := includedBlockName(path)
+            if err != nil {
+                return err
+            }
+            source.name = name
+        }
+        source.included = included
+
+        for _, other := range reader.sources {
+            if source.name != "" && strings.EqualFold(other.name, source.name) {
+                return fmt.Errorf("Table '%s' is already defined", source.name)
+            }
+        }
+        reader.sources = append(reader.sources, source)
+    }
+
+    return nil
+}
+
+// Reads the file an '#include' line in another file names.
+func (reader *includeReader) readInclude(line string, from string) error {
+    include, err := parseInclude(line, filepath.Dir(from))
+    if err != nil {
+        return err
+    }
+    return reader.read(include, true)
+}
+
+// Reads the blocks of a file and every file it includes, so they can all
+// be evaluated as one table.
+func readSources(filePath string) ([]blockSource, error) {
+    path, err := filepath.Abs(filePath)
+    if err != nil {
+        return nil, err
+    }
+
+    reader := includeReader {
+        seen: make(map[string]bool),
+    }
+    if err := reader.read(path, false); err != nil {
+        return nil, err
+    }
+    return reader.sources, nil
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"flag"
 	"fmt"
 	"os"
@@ -10,8 +11,24 @@ import (
 func main() {
     outputFile := flag.String("output", "", "Specify output file")
     help := flag.Bool("help", false, "Show help screen")
+    jobs := flag.Int("jobs", 1, "Evaluate on N workers, or GOMAXPROCS if 0")
+    format := flag.String("format", "text",
+        "Write output as text, csv, tsv, json, markdown, html or latex")
+    inputFormat := flag.String("input-format", "",
+        "Read input as cell, csv or tsv, instead of by its extension")
+    alignDecimal := flag.Bool("align-decimal", false,
+        "Line numbers up on their decimal point in text output")
+    maxWidth := flag.Int("max-width", 0,
+        "Cut text down to N characters wide in text output, or 0 for no limit")
+    wrap := flag.Bool("wrap", false, "Wrap cells wider than -max-width instead of cutting them")
+    check := flag.Bool("check", false, "Print any cells or directives with errors, instead of the table")
     flag.Parse()
 
+    if flag.Arg(0) == "fmt" {
+        formatFiles(flag.Args()[1:])
+        return
+    }
+
     inputFile := flag.Arg(0)
     if inputFile == "" {
         fmt.Fprintln(os.Stderr, "No input file given")
@@ -24,26 +41,124 @@ func main() {
         os.Exit(1)
     }
 
-    table, err := readTable(inputFile)
+    renderer, found := LookupRenderer(*format)
+    if !found {
+        fmt.Printf("Error: Unknown output format '%s'\n", *format)
+        os.Exit(1)
+    }
+
+    if text, ok := renderer.(TextRenderer); ok {
+        text.options = PrintOptions {
+            alignDecimal: *alignDecimal,
+            maxWidth: *maxWidth,
+            wrap: *wrap,
+        }
+        renderer = text
+    }
+
+    table, err := readTable(inputFile, *inputFormat)
     if err != nil {
         fmt.Println("Error:", err)
         os.Exit(1)
     }
 
-    table.Evaluate()
+    if *check {
+        checkTable(&table)
+        return
+    }
+
+    if len(table.diagnostics) > 0 {
+        for _, diagnostic := range table.diagnostics {
+            fmt.Println("Error:", diagnostic)
+        }
+        os.Exit(1)
+    }
 
-    if *outputFile == "" {
-        table.Print(os.Stdout)
+    if *jobs == 1 {
+        table.Evaluate()
     } else {
-        file, err := os.Create(*outputFile)
-        if err != nil {
-            panic(err)
+        table.EvaluateParallel(*jobs)
+    }
+
+    if err := writeOutput(renderer, &table, *outputFile); err != nil {
+        fmt.Println("Error:", err)
+        os.Exit(1)
+    }
+}
+
+// Renders the table to the output file, or to stdout if there isn't one.
+func writeOutput(renderer Renderer, table *Table, outputFile string) error {
+    if outputFile == "" {
+        return renderer.Render(os.Stdout, table)
+    }
+
+    file, err := os.Create(outputFile)
+    if err != nil {
+        return err
+    }
+
+    writer := bufio.NewWriter(file)
+    err = renderer.Render(writer, table)
+    if flushErr := writer.Flush(); err == nil {
+        err = flushErr
+    }
+    if closeErr := file.Close(); err == nil {
+        err = closeErr
+    }
+    return err
+}
+
+// Prints each directive and cell that couldn't be read, and exits with an error if
+// there were any.
+func checkTable(table *Table) {
+    diagnostics := table.Diagnostics()
+    for _, diagnostic := range diagnostics {
+        fmt.Fprintln(os.Stderr, diagnostic)
+    }
+
+    if len(diagnostics) > 0 {
+        os.Exit(1)
+    }
+}
+
+// Rewrites each .cell file in place in canonical form.
+func formatFiles(files []string) {
+    if len(files) == 0 {
+        fmt.Fprintln(os.Stderr, "No files given to format")
+        os.Exit(1)
+    }
+
+    failed := false
+    for _, file := range files {
+        if err := formatFile(file); err != nil {
+            fmt.Printf("Error: %s: %s\n", file, err)
+            failed = true
         }
+    }
 
-        writer := bufio.NewWriter(file)
-        table.Print(writer)
-        writer.Flush()
-        file.Close()
+    if failed {
+        os.Exit(1)
     }
 }
 
+func formatFile(file string) error {
+    info, err := os.Stat(file)
+    if err != nil {
+        return err
+    }
+
+    input, err := os.ReadFile(file)
+    if err != nil {
+        return err
+    }
+
+    output, err := FormatSource(input)
+    if err != nil {
+        return err
+    }
+    if bytes.Equal(input, output) {
+        return nil
+    }
+
+    return os.WriteFile(file, output, info.Mode())
+}
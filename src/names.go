@@ -0,0 +1,226 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+func isDirective(line string) bool {
+    return len(line) > 0 && line[0] == '#'
+}
+
+// Reads a line like '#define Pi = C3' or '#define Rates = B2:B8'. Named
+// referances are always absolute, so they don't move when cloned.
+func parseDefine(line string) (string, Expression, error) {
+    text := strings.TrimSpace(strings.TrimPrefix(line, "#define"))
+    name, text, err := parseName(text)
+    if err != nil || len(name.name) == 0 {
+        return "", Expression{}, fmt.Errorf("Expected name in '%s'", line)
+    }
+    if parseKeyword(name).kind != TokenName {
+        return "", Expression{}, fmt.Errorf("Name '%s' is a keyword", name.name)
+    }
+
+    text, err = expectChar('=', strings.TrimLeft(text, " "))
+    if err != nil {
+        return "", Expression{}, fmt.Errorf("Expected '=' after name '%s'", name.name)
+    }
+
+    text = strings.TrimSpace(text)
+    if len(text) == 0 || !(isLetter(text[0]) || text[0] == '$') {
+        return "", Expression{}, fmt.Errorf(
+            "Name '%s' must refer to a cell or range like C3 or A1:A5", name.name)
+    }
+
+    token, text, err := nextToken(text, CellPosition{})
+    if err != nil {
+        return "", Expression{}, fmt.Errorf("Invalid referance for '%s': %s", name.name, err)
+    }
+    if strings.TrimSpace(text) != "" {
+        return "", Expression{}, fmt.Errorf("Unexpected '%s' after referance", text)
+    }
+
+    all := Anchors { row: true, column: true }
+    switch token.kind {
+    case TokenCell:
+        return name.name, Expression {
+            kind: ExpressionCell,
+            position: token.position,
+            anchors: all,
+            table: token.table,
+        }, nil
+    case TokenRange:
+        cellRange := token.cellRange
+        cellRange.startAnchors, cellRange.endAnchors = all, all
+        return name.name, Expression {
+            kind: ExpressionRange,
+            cellRange: cellRange,
+            table: token.table,
+        }, nil
+    default:
+        return "", Expression{}, fmt.Errorf(
+            "Name '%s' must refer to a cell or range like C3 or A1:A5", name.name)
+    }
+}
+
+// Reads the names defined in a block, which refer to its own cells unless
+// they name another table. A directive that can't be read is left out,
+// and kept as a diagnostic.
+func (table *Table) readNames(block int, source *blockSource) {
+    for i, record := range source.records {
+        line, ok := source.directive(record)
+        if !ok || isInclude(line) {
+            continue
+        }
+
+        if err := table.readName(block, line); err != nil {
+            table.diagnostics = append(table.diagnostics, Diagnostic {
+                file: source.file,
+                position: source.positions[i][0],
+                message: err.Error(),
+            })
+        }
+    }
+}
+
+func (table *Table) readName(block int, line string) error {
+    if !strings.HasPrefix(line, "#define ") {
+        return fmt.Errorf("Unknown directive '%s'", line)
+    }
+
+    name, definition, err := parseDefine(line)
+    if err != nil {
+        return err
+    }
+    if err := table.placeExpression(&definition, block); err != nil {
+        return fmt.Errorf("Name '%s': %s", name, err)
+    }
+
+    key := strings.ToLower(name)
+    if _, found := table.names[key]; found {
+        return fmt.Errorf("Name '%s' is already defined", name)
+    }
+    table.names[key] = definition
+    return nil
+}
+
+func (table *Table) isBlankRow(row int) bool {
+    for column := 0; column < table.columns; column++ {
+        if !table.IsEmpty(CellPosition { row, column }) {
+            return false
+        }
+    }
+    return true
+}
+
+// The first row of the block a row is in, where blocks are separated by
+// blank lines or start a new table.
+func (table *Table) headerRow(row int) int {
+    start := 0
+    if index := table.blockAt(row); index != -1 {
+        start = table.blocks[index].start
+    }
+
+    for row > start && !table.isBlankRow(row - 1) {
+        row -= 1
+    }
+    return row
+}
+
+// Checks if a row is the first of its block, without walking back to it.
+func (table *Table) isHeaderRow(row int) bool {
+    index := table.blockAt(row)
+    if index == -1 || row == table.blocks[index].start {
+        return true
+    }
+    return table.isBlankRow(row - 1)
+}
+
+// Finds the column with a header, ignoring clones, which are still
+// unresolved when headers are first found.
+func (table *Table) findHeader(name string, row int) (int, bool) {
+    header := table.headerRow(row)
+    for column := 0; column < table.columns; column++ {
+        cell := table.CellAt(CellPosition { header, column })
+        if (cell.kind == CellText || cell.kind == CellNumber) && !cell.cloned &&
+            strings.EqualFold(cell.String(), name) {
+            return column, true
+        }
+    }
+    return -1, false
+}
+
+// Replaces named referances with what they were defined as, keeping the
+// name around. Header referances become a referance to that column in the
+// same row. Anything left unresolved will fail when evaluated.
+func (table *Table) resolveExpressionNames(expression *Expression) {
+    if expression == nil {
+        return
+    }
+
+    switch expression.kind {
+    case ExpressionName:
+        if definition, found := table.names[strings.ToLower(expression.name)]; found {
+            name := expression.name
+            *expression = definition
+            expression.name = name
+        }
+    case ExpressionHeader:
+        if column, found := table.findHeader(expression.name, expression.position.row); found {
+            expression.kind = ExpressionCell
+            expression.position.column = column
+            expression.anchors = Anchors { column: true }
+        }
+    case ExpressionFunction:
+        for _, argument := range expression.arguments {
+            table.resolveExpressionNames(argument)
+        }
+    default:
+        table.resolveExpressionNames(expression.lhs)
+        table.resolveExpressionNames(expression.rhs)
+    }
+}
+
+// Puts header referances back the way they were parsed, so they can be
+// found again after a header has changed.
+func resetHeaders(expression *Expression) {
+    if expression == nil {
+        return
+    }
+    if expression.header {
+        expression.kind = ExpressionHeader
+        expression.anchors = Anchors{}
+        return
+    }
+
+    for _, argument := range expression.arguments {
+        resetHeaders(argument)
+    }
+    resetHeaders(expression.lhs)
+    resetHeaders(expression.rhs)
+}
+
+// Finds the column of every header referance again.
+func (table *Table) resolveHeaders() {
+    resolved := make(map[*Expression]bool)
+    for index := range table.content {
+        expression := table.content[index].expression
+        if expression != nil && !resolved[expression] &&
+            containsExpression(expression, isHeaderReferance) {
+            resetHeaders(expression)
+            table.resolveExpressionNames(expression)
+            resolved[expression] = true
+        }
+    }
+}
+
+func (table *Table) resolveNames() {
+    resolved := make(map[*Expression]bool)
+    for index := range table.content {
+        expression := table.content[index].expression
+        if expression != nil && !resolved[expression] {
+            table.resolveExpressionNames(expression)
+            resolved[expression] = true
+        }
+    }
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// How a cell's number is displayed, written after a ';' at the end of the
+// cell, like '=<1 * 2 ;0.00'. A text cell with one sets it for the rest
+// of its column.
+type NumberFormat struct {
+    spec string
+
+    // Digits after the point, or -1 for as many as are needed.
+    decimals int
+
+    grouped bool
+    percent bool
+    currency bool
+    scientific bool
+
+    // Set when the format is from a text cell, and so is shared with the
+    // cells under it, rather than being a cell's own.
+    column bool
+}
+
+// Rounds off the noise in the last couple of digits, so 3.14 + 1 is
+// displayed as 4.14. Only a number that comes out with at least two
+// trailing zeros at 15 significant digits is rounded, so real digits, like
+// those of sqrt(2) or 2^60, are kept.
+func roundSignificant(number float64) float64 {
+    if math.Abs(number) >= 1e15 {
+        return number
+    }
+
+    text := strconv.FormatFloat(number, 'e', 14, 64)
+    if !strings.HasSuffix(text[:strings.IndexByte(text, 'e')], "00") {
+        return number
+    }
+
+    rounded, err := strconv.ParseFloat(text, 64)
+    if err != nil || rounded == 0 {
+        return 0
+    }
+    return rounded
+}
+
+func formatCellNumber(number float64) string {
+    if math.IsNaN(number) || math.IsInf(number, 0) {
+        return strconv.FormatFloat(number, 'f', -1, 64)
+    }
+    return strconv.FormatFloat(roundSignificant(number), 'f', -1, 64)
+}
+
+// Reads a pattern like '0.00' or '1,234.0', giving the number of digits
+// after the point and if thousands are grouped.
+func parseDigitPattern(pattern string) (int, bool, bool) {
+    whole, fraction, hasPoint := cutPoint(pattern)
+    grouped := false
+    switch whole {
+    case "0":
+    case "1,234":
+        grouped = true
+    default:
+        return 0, false, false
+    }
+
+    if !hasPoint {
+        return 0, grouped, true
+    }
+    if len(fraction) == 0 || strings.Trim(fraction, "0") != "" {
+        return 0, false, false
+    }
+    return len(fraction), grouped, true
+}
+
+// Reads a format spec, which is either 'sci', or a pattern like '0.00' or
+// '1,234' with an optional '$' before it or '%' after it.
+func parseNumberFormat(spec string) (NumberFormat, error) {
+    spec = strings.TrimSpace(spec)
+    format := NumberFormat { spec: spec, decimals: -1 }
+    if spec == "sci" {
+        format.scientific = true
+        return format, nil
+    }
+
+    pattern := spec
+    if strings.HasPrefix(pattern, "$") {
+        format.currency, format.grouped, format.decimals = true, true, 2
+        pattern = pattern[1:]
+    }
+    if strings.HasSuffix(pattern, "%") {
+        format.percent = true
+        pattern = pattern[:len(pattern) - 1]
+    }
+
+    if pattern != "" {
+        decimals, grouped, ok := parseDigitPattern(pattern)
+        if !ok {
+            return NumberFormat{}, fmt.Errorf("Unknown number format '%s'", spec)
+        }
+        format.decimals = decimals
+        format.grouped = format.grouped || grouped
+    } else if !format.currency && !format.percent {
+        return NumberFormat{}, fmt.Errorf("Unknown number format '%s'", spec)
+    }
+
+    return format, nil
+}
+
+func cutPoint(text string) (string, string, bool) {
+    point := strings.IndexByte(text, '.')
+    if point == -1 {
+        return text, "", false
+    }
+    return text[:point], text[point+1:], true
+}
+
+func groupThousands(text string) string {
+    whole, fraction, hasPoint := cutPoint(text)
+    var builder strings.Builder
+    for i := 0; i < len(whole); i++ {
+        if i > 0 && (len(whole) - i) % 3 == 0 {
+            builder.WriteByte(',')
+        }
+        builder.WriteByte(whole[i])
+    }
+
+    if hasPoint {
+        builder.WriteString("." + fraction)
+    }
+    return builder.String()
+}
+
+func (format NumberFormat) Format(number float64) string {
+    if math.IsNaN(number) || math.IsInf(number, 0) {
+        return formatCellNumber(number)
+    }
+    if format.scientific {
+        return strconv.FormatFloat(roundSignificant(number), 'e', -1, 64)
+    }
+
+    if format.percent {
+        number *= 100
+    }
+
+    negative := number < 0
+    text := formatCellNumber(math.Abs(number))
+    if format.decimals >= 0 {
+        text = strconv.FormatFloat(math.Abs(number), 'f', format.decimals, 64)
+    }
+
+    if format.grouped {
+        text = groupThousands(text)
+    }
+    if format.currency {
+        text = "$" + text
+    }
+    if format.percent {
+        text += "%"
+    }
+    if negative && strings.ContainsAny(text, "123456789") {
+        text = "-" + text
+    }
+    return text
+}
+
+// Finds the ';' starting a format spec, skipping any inside a string.
+// Anything after it that isn't a known format is left as part of the text.
+func cutNumberFormat(text string) (string, NumberFormat, bool) {
+    inString := false
+    last := -1
+    for i := 0; i < len(text); i++ {
+        switch {
+        case inString && text[i] == '\\':
+            i += 1
+        case text[i] == '"':
+            inString = !inString
+        case text[i] == ';' && !inString:
+            last = i
+        }
+    }
+
+    if last == -1 {
+        return text, NumberFormat{}, false
+    }
+
+    format, err := parseNumberFormat(text[last+1:])
+    if err != nil {
+        return text, NumberFormat{}, false
+    }
+    return strings.TrimSpace(text[:last]), format, true
+}
+
+// Gives each cell under a text cell with a format that same format,
+// unless it has one of its own.
+func (table *Table) applyColumnFormats() {
+    for block := range table.blocks {
+        for column := 0; column < table.columns; column++ {
+            table.applyColumnFormat(block, column)
+        }
+    }
+}
+
+// Formats already given by a text cell are replaced, so this can be run
+// again after a column has been edited.
+func (table *Table) applyColumnFormat(block int, column int) {
+    var format *NumberFormat
+    start := table.blocks[block].start
+    for row := start; row < start + table.blocks[block].rows; row++ {
+        cell := table.CellAt(CellPosition { row, column })
+        switch {
+        case cell.kind == CellText:
+            if cell.format != nil {
+                format = cell.format
+            }
+        case cell.format == nil || cell.format.column:
+            cell.format = format
+        }
+    }
+}
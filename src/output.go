@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+)
+
+// The blocks that get written out, leaving out included files and an
+// unnamed block with nothing but directives in it.
+func (table *Table) outputBlocks() []TableBlock {
+    blocks := make([]TableBlock, 0, len(table.blocks))
+    for _, block := range table.blocks {
+        if block.included || (block.rows == 0 && block.name == "") {
+            continue
+        }
+        blocks = append(blocks, block)
+    }
+    return blocks
+}
+
+// The text written for a cell in CSV and TSV, where seporators are left
+// empty.
+func (table *Table) fieldText(position CellPosition) string {
+    cell := table.CellAt(position)
+    if cell.kind == CellSeporator {
+        return ""
+    }
+    return cell.String()
+}
+
+// Every row is written with the same number of fields as its block, and
+// blocks are separated by an empty line.
+func (table *Table) writeDelimited(output io.Writer, comma rune) error {
+    writer := csv.NewWriter(output)
+    writer.Comma = comma
+    for i, block := range table.outputBlocks() {
+        if i > 0 {
+            writer.Flush()
+            if _, err := output.Write([]byte{ '\n' }); err != nil {
+                return err
+            }
+        }
+
+        record := make([]string, block.columns)
+        for row := block.start; row < block.start + block.rows; row++ {
+            for column := range record {
+                record[column] = table.fieldText(CellPosition { row, column })
+            }
+            if err := writer.Write(record); err != nil {
+                return err
+            }
+        }
+    }
+
+    writer.Flush()
+    return writer.Error()
+}
+
+func (table *Table) WriteCSV(output io.Writer) error {
+    return table.writeDelimited(output, ',')
+}
+
+func (table *Table) WriteTSV(output io.Writer) error {
+    return table.writeDelimited(output, '\t')
+}
+
+type jsonCell struct {
+    Kind string `json:"kind"`
+    Value interface{} `json:"value,omitempty"`
+    Error string `json:"error,omitempty"`
+}
+
+type jsonTable struct {
+    Name string `json:"name,omitempty"`
+    Rows [][]jsonCell `json:"rows"`
+}
+
+func (table *Table) jsonCellAt(position CellPosition) jsonCell {
+    cell := table.CellAt(position)
+    if cell.kind == CellSeporator {
+        return jsonCell { Kind: "separator" }
+    }
+
+    value := cell.Value()
+    switch value.kind {
+    case ValueEmpty:
+        return jsonCell { Kind: "empty" }
+    case ValueNumber:
+        // JSON has no infinity or NaN, so they're written as text.
+        if math.IsInf(value.number, 0) || math.IsNaN(value.number) {
+            return jsonCell { Kind: "number", Value: formatCellNumber(value.number) }
+        }
+        return jsonCell { Kind: "number", Value: value.number }
+    case ValueString:
+        return jsonCell { Kind: "text", Value: value.text }
+    case ValueBoolean:
+        return jsonCell { Kind: "boolean", Value: value.boolean }
+    case ValueError:
+        return jsonCell { Kind: "error", Error: fmt.Sprint(value.err) }
+    default:
+        panic(0)
+    }
+}
+
+// Writes each block as a list of rows, with every cell's kind along with
+// its value or error.
+func (table *Table) WriteJSON(output io.Writer) error {
+    blocks := table.outputBlocks()
+    tables := make([]jsonTable, len(blocks))
+    for i, block := range blocks {
+        tables[i] = jsonTable {
+            Name: block.name,
+            Rows: make([][]jsonCell, block.rows),
+        }
+
+        for row := range tables[i].Rows {
+            cells := make([]jsonCell, block.columns)
+            for column := range cells {
+                cells[column] = table.jsonCellAt(CellPosition { block.start + row, column })
+            }
+            tables[i].Rows[row] = cells
+        }
+    }
+
+    encoder := json.NewEncoder(output)
+    encoder.SetIndent("", "  ")
+    return encoder.Encode(struct {
+        Tables []jsonTable `json:"tables"`
+    } { tables })
+}
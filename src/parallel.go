@@ -0,0 +1,85 @@
+package main
+
+import (
+	"runtime"
+	"sync"
+)
+
+func findComponent(parents []int32, index int32) int32 {
+    for parents[index] != index {
+        parents[index] = parents[parents[index]]
+        index = parents[index]
+    }
+    return index
+}
+
+// Groups the ordered cells into sets that never reference each other,
+// keeping each set in dependency order. Only referances to other
+// expressions join sets, as cells that aren't evaluated are never
+// written to and can be read from any worker.
+func (table *Table) independentComponents(graph *DependencyGraph, order []int) [][]int {
+    parents := make([]int32, len(table.content))
+    for i := range parents {
+        parents[i] = int32(i)
+    }
+
+    for _, index := range order {
+        for _, dependency := range graph.Dependencies(index) {
+            if table.content[dependency].kind != CellExpression {
+                continue
+            }
+
+            a := findComponent(parents, int32(index))
+            b := findComponent(parents, dependency)
+            if a != b {
+                parents[a] = b
+            }
+        }
+    }
+
+    componentOf := make(map[int32]int)
+    components := make([][]int, 0)
+    for _, index := range order {
+        root := findComponent(parents, int32(index))
+        component, found := componentOf[root]
+        if !found {
+            component = len(components)
+            componentOf[root] = component
+            components = append(components, make([]int, 0))
+        }
+
+        components[component] = append(components[component], index)
+    }
+
+    return components
+}
+
+// Evaluates independent parts of the table on a pool of workers. Each part
+// is still evaluated in dependency order by a single worker, so the result
+// is the same as Evaluate. A jobs count below one uses GOMAXPROCS.
+func (table *Table) EvaluateParallel(jobs int) {
+    if jobs < 1 {
+        jobs = runtime.GOMAXPROCS(0)
+    }
+
+    order := table.prepareEvaluation()
+    components := table.independentComponents(&table.graph, order)
+
+    work := make(chan []int)
+    var group sync.WaitGroup
+    for i := 0; i < jobs; i++ {
+        group.Add(1)
+        go func() {
+            defer group.Done()
+            for component := range work {
+                table.evaluateInOrder(component)
+            }
+        }()
+    }
+
+    for _, component := range components {
+        work <- component
+    }
+    close(work)
+    group.Wait()
+}
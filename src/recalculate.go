@@ -0,0 +1,205 @@
+package main
+
+import (
+	"errors"
+)
+
+func (table *Table) isInside(position CellPosition) bool {
+    return position.row >= 0 && position.row < table.rows &&
+        position.column >= 0 && position.column < table.columns
+}
+
+// Replaces the cell at position with newly parsed text. The change is
+// copied along to any clones of the cell, and everything depending on
+// them is marked dirty, ready for Recalculate.
+func (table *Table) SetCell(position CellPosition, text string) error {
+    if !table.isInside(position) {
+        return errors.New("Cell outside table")
+    }
+
+    block := table.blockAt(position.row)
+    if block == -1 {
+        return errors.New("Cell outside table")
+    }
+
+    local := CellPosition { position.row - table.blocks[block].start, position.column }
+    index := table.indexOf(position)
+    cell := &table.content[index]
+    if table.evaluated && cell.cloned {
+        source := position.Offset(cell.direction, cell.offset)
+        if table.isInside(source) {
+            table.clones.Remove(table.indexOf(source), int32(index))
+        }
+    }
+
+    wasSeporator := cell.kind == CellSeporator
+    *cell = table.parseCellAt(text, block, local)
+    table.resolveExpressionNames(cell.expression)
+    table.applyColumnFormat(block, position.column)
+
+    changed := []int { index }
+    if table.evaluated {
+        changed = table.copyToClones(index)
+    }
+
+    // Clones are copied first, so any of them in a header row have their
+    // new text by the time headers are found again.
+    header := false
+    for _, index := range changed {
+        header = header || table.isHeaderRow(table.positionOf(index).row)
+    }
+    if header {
+        table.resolveHeaders()
+    }
+    if !table.evaluated {
+        return nil
+    }
+
+    for _, index := range changed {
+        dependencies := table.cellDependencies(nil, &table.content[index])
+        table.graph.SetDependencies(index, dependencies)
+    }
+
+    // Moving a seporator or renaming a column changes which cells some
+    // referances point at, not just their values.
+    if header {
+        changed = table.refreshDependencies(changed, isHeaderReferance)
+    }
+    if wasSeporator || cell.kind == CellSeporator {
+        changed = table.refreshDependencies(changed, isSeporatorRange)
+    }
+
+    table.invalidate(changed)
+    return nil
+}
+
+// Resolves the cell if it's now a clone, then copies it along to each of
+// its clones in turn. Returns every cell that changed.
+func (table *Table) copyToClones(index int) []int {
+    changed := []int { index }
+    seen := map[int]bool { index: true }
+
+    position := table.positionOf(index)
+    if cell := &table.content[index]; cell.kind == CellClone {
+        source := position.Offset(cell.direction, cell.offset)
+        if table.isInside(source) {
+            table.clones.Add(table.indexOf(source), int32(index))
+        }
+
+        // The other clones in a cycle were resolved before, so they have
+        // to be followed through their copies.
+        if cycle := table.findCloneCycle(position); cycle != nil {
+            table.markCloneCycle(cycle)
+            for _, member := range cycle[1:] {
+                changed = append(changed, table.indexOf(member))
+                seen[table.indexOf(member)] = true
+            }
+        } else {
+            table.resolveClone(position)
+        }
+    }
+
+    for i := 0; i < len(changed); i++ {
+        for _, clone := range table.clones.Get(changed[i]) {
+            if seen[int(clone)] {
+                continue
+            }
+
+            table.copyClone(&table.content[clone], table.positionOf(int(clone)))
+            changed = append(changed, int(clone))
+            seen[int(clone)] = true
+        }
+    }
+    return changed
+}
+
+func isHeaderReferance(expression *Expression) bool {
+    return expression.header
+}
+
+func isSeporatorRange(expression *Expression) bool {
+    return expression.kind == ExpressionRange && expression.cellRange.toSeporator
+}
+
+// Checks if any part of the expression matches.
+func containsExpression(expression *Expression, matches func(*Expression) bool) bool {
+    if expression == nil {
+        return false
+    }
+    if matches(expression) {
+        return true
+    }
+
+    for _, argument := range expression.arguments {
+        if containsExpression(argument, matches) {
+            return true
+        }
+    }
+    return containsExpression(expression.lhs, matches) ||
+        containsExpression(expression.rhs, matches)
+}
+
+// Rebuilds the dependencies of every expression with a part that matches,
+// adding them to the changed cells.
+func (table *Table) refreshDependencies(changed []int, matches func(*Expression) bool) []int {
+    for index := range table.content {
+        cell := &table.content[index]
+        if cell.kind != CellExpression || !containsExpression(cell.expression, matches) {
+            continue
+        }
+
+        table.graph.SetDependencies(index, table.cellDependencies(nil, cell))
+        changed = append(changed, index)
+    }
+    return changed
+}
+
+// Marks the changed cells, and every cell depending on them, as needing
+// to be evaluated again.
+func (table *Table) invalidate(changed []int) {
+    pending := append([]int{}, changed...)
+    for _, index := range changed {
+        table.content[index].evaluationState = EvaluationPending
+    }
+
+    for len(pending) > 0 {
+        index := pending[len(pending) - 1]
+        pending = pending[:len(pending) - 1]
+        table.dirty = append(table.dirty, index)
+
+        for _, dependent := range table.graph.Dependents(index) {
+            cell := &table.content[dependent]
+            if cell.evaluationState == EvaluationDone {
+                cell.evaluationState = EvaluationPending
+                pending = append(pending, int(dependent))
+            }
+        }
+    }
+}
+
+// Evaluates only the cells made dirty by SetCell since the last time the
+// table was evaluated.
+func (table *Table) Recalculate() {
+    if !table.evaluated {
+        table.Evaluate()
+        return
+    }
+
+    states := make([]visitState, len(table.content))
+    for i := range states {
+        states[i] = visitDone
+    }
+    for _, index := range table.dirty {
+        states[index] = visitPending
+    }
+
+    search := newCellOrder(&table.graph, states)
+    for _, index := range table.dirty {
+        if states[index] == visitPending {
+            table.visit(&search, index)
+        }
+    }
+
+    table.evaluateInOrder(search.order)
+    table.dirty = nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type cellEdit struct {
+    position CellPosition
+    text string
+}
+
+func readTestTable(t *testing.T, text string) Table {
+    path := filepath.Join(t.TempDir(), "test.cell")
+    if err := os.WriteFile(path, []byte(text), 0644); err != nil {
+        t.Fatal(err)
+    }
+
+    table, err := readTable(path, "")
+    if err != nil {
+        t.Fatal(err)
+    }
+    return table
+}
+
+func printTable(table *Table) string {
+    var output strings.Builder
+    table.Print(&output)
+    return output.String()
+}
+
+// Edits an evaluated table with SetCell and Recalculate, and checks it
+// comes out the same as reading and evaluating the edited text fresh.
+func checkRecalculate(t *testing.T, before string, edits []cellEdit, after string) {
+    t.Helper()
+
+    table := readTestTable(t, before)
+    table.Evaluate()
+    for _, edit := range edits {
+        if err := table.SetCell(edit.position, edit.text); err != nil {
+            t.Fatal(err)
+        }
+    }
+    table.Recalculate()
+
+    fresh := readTestTable(t, after)
+    fresh.Evaluate()
+
+    got, want := printTable(&table), printTable(&fresh)
+    if got != want {
+        t.Errorf("after SetCell got\n%s\nbut reading the edited table gives\n%s", got, want)
+    }
+}
+
+func TestRecalculateHeaderClone(t *testing.T) {
+    checkRecalculate(t,
+        "h1 | :<\n5  | =@h1\n",
+        []cellEdit { { CellPosition { 0, 0 }, "zz" } },
+        "zz | :<\n5  | =@h1\n")
+}
+
+func TestRecalculateSeporator(t *testing.T) {
+    checkRecalculate(t,
+        "A\n1\n2\n3\n=sum(A2:A_)\n",
+        []cellEdit { { CellPosition { 2, 0 }, "__" } },
+        "A\n1\n__\n3\n=sum(A2:A_)\n")
+}
+
+func TestRecalculateCloneOfHeader(t *testing.T) {
+    checkRecalculate(t,
+        "a | h1\n5 | =@h1\n",
+        []cellEdit { { CellPosition { 0, 0 }, ":>" } },
+        ":> | h1\n5  | =@h1\n")
+}
+
+func TestRecalculateCloneCycle(t *testing.T) {
+    checkRecalculate(t,
+        "A | B\n1 | 2\n3 | :<\n5 | :^\n",
+        []cellEdit { { CellPosition { 2, 0 }, ":>" } },
+        "A  | B\n1  | 2\n:> | :<\n5  | :^\n")
+    checkRecalculate(t,
+        "A | B  | C\n1 | :> | 2\n",
+        []cellEdit { { CellPosition { 1, 2 }, ":<" } },
+        "A | B  | C\n1 | :> | :<\n")
+}
+
+func TestRecalculateBreakCloneCycle(t *testing.T) {
+    checkRecalculate(t,
+        "A  | B\n1  | 2\n:> | :<\n",
+        []cellEdit { { CellPosition { 2, 0 }, "=A2 + 1" } },
+        "A       | B\n1       | 2\n=A2 + 1 | :<\n")
+}
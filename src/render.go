@@ -0,0 +1,249 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"strings"
+)
+
+// Writes an evaluated table out in some format.
+type Renderer interface {
+    Render(output io.Writer, table *Table) error
+}
+
+// Lets a plain function, like a method expression on Table, be used as
+// a Renderer.
+type RendererFunc func(*Table, io.Writer) error
+
+func (function RendererFunc) Render(output io.Writer, table *Table) error {
+    return function(table, output)
+}
+
+// The aligned, pipe seporated text written by Print.
+type TextRenderer struct {
+    options PrintOptions
+}
+
+func (renderer TextRenderer) Render(output io.Writer, table *Table) error {
+    return table.PrintWith(output, renderer.options)
+}
+
+var renderers = map[string]Renderer {
+    "text": TextRenderer{},
+    "csv": RendererFunc((*Table).WriteCSV),
+    "tsv": RendererFunc((*Table).WriteTSV),
+    "json": RendererFunc((*Table).WriteJSON),
+    "markdown": MarkdownRenderer{},
+    "html": HTMLRenderer{},
+    "latex": LaTeXRenderer{},
+}
+
+func LookupRenderer(format string) (Renderer, bool) {
+    renderer, found := renderers[strings.ToLower(format)]
+    return renderer, found
+}
+
+// A row where every cell that isn't empty is a seporator.
+func (table *Table) isSeporatorRow(row int, block TableBlock) bool {
+    found := false
+    for column := 0; column < block.columns; column++ {
+        switch table.CellAt(CellPosition { row, column }).kind {
+        case CellSeporator:
+            found = true
+        case CellEmpty:
+        default:
+            return false
+        }
+    }
+    return found
+}
+
+// Columns are right aligned if everything under their header is a number.
+func (table *Table) numberColumns(block TableBlock) []bool {
+    numbers := make([]bool, block.columns)
+    for column := range numbers {
+        for row := block.start + 1; row < block.start + block.rows; row++ {
+            cell := table.CellAt(CellPosition { row, column })
+            if cell.kind == CellSeporator || cell.kind == CellEmpty {
+                continue
+            }
+            if cell.Value().kind != ValueNumber {
+                numbers[column] = false
+                break
+            }
+            numbers[column] = true
+        }
+    }
+    return numbers
+}
+
+// Keeps the first error from writing, so each line doesn't need checking.
+type errorWriter struct {
+    output io.Writer
+    err error
+}
+
+func (writer *errorWriter) printf(format string, arguments ...interface{}) {
+    if writer.err == nil {
+        _, writer.err = fmt.Fprintf(writer.output, format, arguments...)
+    }
+}
+
+// GitHub flavoured Markdown. The first row of each block is its header,
+// and as tables can't hold a rule, seporators are written as '---'.
+type MarkdownRenderer struct {}
+
+var markdownEscaper = strings.NewReplacer(
+    "\\", "\\\\", "|", "\\|", "*", "\\*", "_", "\\_", "`", "\\`",
+    "[", "\\[", "]", "\\]", "<", "&lt;", ">", "&gt;")
+
+func (MarkdownRenderer) Render(output io.Writer, table *Table) error {
+    writer := errorWriter { output: output }
+    for i, block := range table.outputBlocks() {
+        if i > 0 {
+            writer.printf("\n")
+        }
+        if block.name != "" {
+            writer.printf("### %s\n\n", markdownEscaper.Replace(block.name))
+        }
+
+        for row := block.start; row < block.start + block.rows; row++ {
+            writer.printf("|")
+            for column := 0; column < block.columns; column++ {
+                cell := table.CellAt(CellPosition { row, column })
+                text := markdownEscaper.Replace(cell.String())
+                if cell.kind == CellSeporator {
+                    text = "---"
+                }
+                writer.printf(" %s |", text)
+            }
+            writer.printf("\n")
+
+            if row == block.start {
+                writer.printf("|")
+                for _, number := range table.numberColumns(block) {
+                    if number {
+                        writer.printf(" ---: |")
+                    } else {
+                        writer.printf(" --- |")
+                    }
+                }
+                writer.printf("\n")
+            }
+        }
+    }
+
+    return writer.err
+}
+
+// An HTML <table> for each block, with its first row as the header and
+// seporator rows as an <hr>.
+type HTMLRenderer struct {}
+
+func (HTMLRenderer) Render(output io.Writer, table *Table) error {
+    writer := errorWriter { output: output }
+    for _, block := range table.outputBlocks() {
+        writer.printf("<table>\n")
+        if block.name != "" {
+            writer.printf("  <caption>%s</caption>\n", html.EscapeString(block.name))
+        }
+
+        numbers := table.numberColumns(block)
+        for row := block.start; row < block.start + block.rows; row++ {
+            if row == block.start + 1 {
+                writer.printf("  </thead>\n  <tbody>\n")
+            } else if row == block.start {
+                writer.printf("  <thead>\n")
+            }
+
+            if table.isSeporatorRow(row, block) {
+                writer.printf("    <tr><td colspan=\"%d\"><hr></td></tr>\n", block.columns)
+                continue
+            }
+
+            tag := "td"
+            if row == block.start {
+                tag = "th"
+            }
+
+            writer.printf("    <tr>")
+            for column := 0; column < block.columns; column++ {
+                cell := table.CellAt(CellPosition { row, column })
+                text := html.EscapeString(cell.String())
+                if cell.kind == CellSeporator {
+                    text = "<hr>"
+                }
+
+                if tag == "td" && numbers[column] {
+                    writer.printf("<td align=\"right\">%s</td>", text)
+                } else {
+                    writer.printf("<%s>%s</%s>", tag, text, tag)
+                }
+            }
+            writer.printf("</tr>\n")
+        }
+
+        if block.rows == 1 {
+            writer.printf("  </thead>\n")
+        } else if block.rows > 1 {
+            writer.printf("  </tbody>\n")
+        }
+        writer.printf("</table>\n")
+    }
+
+    return writer.err
+}
+
+// A LaTeX tabular for each block, with a \hline under the header and in
+// place of seporator rows.
+type LaTeXRenderer struct {}
+
+var latexEscaper = strings.NewReplacer(
+    "\\", "\\textbackslash{}", "&", "\\&", "%", "\\%", "$", "\\$",
+    "#", "\\#", "_", "\\_", "{", "\\{", "}", "\\}",
+    "~", "\\textasciitilde{}", "^", "\\textasciicircum{}")
+
+func (LaTeXRenderer) Render(output io.Writer, table *Table) error {
+    writer := errorWriter { output: output }
+    for i, block := range table.outputBlocks() {
+        if i > 0 {
+            writer.printf("\n")
+        }
+        if block.name != "" {
+            writer.printf("\\textbf{%s}\\par\n", latexEscaper.Replace(block.name))
+        }
+
+        alignment := make([]byte, block.columns)
+        for column, number := range table.numberColumns(block) {
+            alignment[column] = 'l'
+            if number {
+                alignment[column] = 'r'
+            }
+        }
+
+        writer.printf("\\begin{tabular}{%s}\n", alignment)
+        for row := block.start; row < block.start + block.rows; row++ {
+            if table.isSeporatorRow(row, block) {
+                writer.printf("\\hline\n")
+                continue
+            }
+
+            cells := make([]string, block.columns)
+            for column := range cells {
+                cell := table.CellAt(CellPosition { row, column })
+                if cell.kind != CellSeporator {
+                    cells[column] = latexEscaper.Replace(cell.String())
+                }
+            }
+
+            writer.printf("%s \\\\\n", strings.Join(cells, " & "))
+            if row == block.start {
+                writer.printf("\\hline\n")
+            }
+        }
+        writer.printf("\\end{tabular}\n")
+    }
+
+    return writer.err
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Collects the numbers in a range, skipping over text, boolean and
+// empty cells.
+func (table *Table) rangeNumbers(cellRange Range) ([]float64, error) {
+    numbers := make([]float64, 0)
+    for row := cellRange.start.row; row <= cellRange.end.row; row++ {
+        for column := cellRange.start.column; column <= cellRange.end.column; column++ {
+            position := CellPosition { row, column }
+
+            value := table.CellAt(position).Value()
+            switch value.kind {
+            case ValueNumber:
+                numbers = append(numbers, value.number)
+            case ValueError:
+                return nil, value.err
+            }
+        }
+    }
+
+    return numbers, nil
+}
+
+// Flattens range and value arguments into a single list of numbers.
+func (table *Table) ArgumentNumbers(arguments []*Expression,
+                                    shiftOffset CellPosition) ([]float64, error) {
+    numbers := make([]float64, 0)
+    for i, argument := range arguments {
+        if argument.kind == ExpressionRange {
+            cellRange, err := table.resolveRange(argument.cellRange, shiftOffset)
+            if err != nil {
+                return nil, NewArgumentError(i, err)
+            }
+
+            rangeNumbers, err := table.rangeNumbers(cellRange)
+            if err != nil {
+                return nil, NewArgumentError(i, err)
+            }
+
+            numbers = append(numbers, rangeNumbers...)
+            continue
+        }
+
+        value, err := table.EvaluateNumberArgument(arguments, i, shiftOffset)
+        if err != nil {
+            return nil, err
+        }
+        numbers = append(numbers, value)
+    }
+
+    return numbers, nil
+}
+
+func sum(table *Table,
+         arguments []*Expression,
+         shiftOffset CellPosition) (Value, error) {
+    numbers, err := table.ArgumentNumbers(arguments, shiftOffset)
+    if err != nil {
+        return Value{}, err
+    }
+
+    return NumberValue(sumOf(numbers)), nil
+}
+
+func average(table *Table,
+             arguments []*Expression,
+             shiftOffset CellPosition) (Value, error) {
+    numbers, err := table.ArgumentNumbers(arguments, shiftOffset)
+    if err != nil {
+        return Value{}, err
+    }
+    if len(numbers) == 0 {
+        return Value{}, errors.New("Cannot average an empty range")
+    }
+
+    return NumberValue(sumOf(numbers) / float64(len(numbers))), nil
+}
+
+func minimum(table *Table,
+             arguments []*Expression,
+             shiftOffset CellPosition) (Value, error) {
+    numbers, err := table.ArgumentNumbers(arguments, shiftOffset)
+    if err != nil {
+        return Value{}, err
+    }
+    if len(numbers) == 0 {
+        return NumberValue(0), nil
+    }
+
+    result := numbers[0]
+    for _, number := range numbers[1:] {
+        result = math.Min(result, number)
+    }
+    return NumberValue(result), nil
+}
+
+func maximum(table *Table,
+             arguments []*Expression,
+             shiftOffset CellPosition) (Value, error) {
+    numbers, err := table.ArgumentNumbers(arguments, shiftOffset)
+    if err != nil {
+        return Value{}, err
+    }
+    if len(numbers) == 0 {
+        return NumberValue(0), nil
+    }
+
+    result := numbers[0]
+    for _, number := range numbers[1:] {
+        result = math.Max(result, number)
+    }
+    return NumberValue(result), nil
+}
+
+func count(table *Table,
+           arguments []*Expression,
+           shiftOffset CellPosition) (Value, error) {
+    numbers, err := table.ArgumentNumbers(arguments, shiftOffset)
+    if err != nil {
+        return Value{}, err
+    }
+
+    return NumberValue(float64(len(numbers))), nil
+}
+
+func counta(table *Table,
+            arguments []*Expression,
+            shiftOffset CellPosition) (Value, error) {
+    total := 0
+    for i, argument := range arguments {
+        if argument.kind != ExpressionRange {
+            if _, err := table.EvaluateArgument(arguments, i, shiftOffset); err != nil {
+                return Value{}, err
+            }
+
+            total += 1
+            continue
+        }
+
+        a, err := table.resolveRange(argument.cellRange, shiftOffset)
+        if err != nil {
+            return Value{}, NewArgumentError(i, err)
+        }
+
+        for row := a.start.row; row <= a.end.row; row++ {
+            for column := a.start.column; column <= a.end.column; column++ {
+                position := CellPosition { row, column }
+
+                value := table.CellAt(position).Value()
+                switch value.kind {
+                case ValueEmpty:
+                case ValueError:
+                    return Value{}, NewArgumentError(i, value.err)
+                default:
+                    total += 1
+                }
+            }
+        }
+    }
+
+    return NumberValue(float64(total)), nil
+}
+
+func median(table *Table,
+            arguments []*Expression,
+            shiftOffset CellPosition) (Value, error) {
+    numbers, err := table.ArgumentNumbers(arguments, shiftOffset)
+    if err != nil {
+        return Value{}, err
+    }
+    if len(numbers) == 0 {
+        return Value{}, errors.New("Cannot take the median of an empty range")
+    }
+
+    sort.Float64s(numbers)
+    middle := len(numbers) / 2
+    if len(numbers) % 2 == 0 {
+        return NumberValue((numbers[middle - 1] + numbers[middle]) / 2), nil
+    }
+    return NumberValue(numbers[middle]), nil
+}
+
+// Returns the most common number, picking the first one found on ties.
+func mode(table *Table,
+          arguments []*Expression,
+          shiftOffset CellPosition) (Value, error) {
+    numbers, err := table.ArgumentNumbers(arguments, shiftOffset)
+    if err != nil {
+        return Value{}, err
+    }
+    counts := make(map[float64]int)
+    result, best := 0.0, 1
+    for _, number := range numbers {
+        counts[number] += 1
+        if counts[number] > best {
+            result, best = number, counts[number]
+        }
+    }
+
+    if best < 2 {
+        return Value{}, errors.New("No number appears more than once")
+    }
+    return NumberValue(result), nil
+}
+
+func variance(table *Table,
+              arguments []*Expression,
+              shiftOffset CellPosition) (Value, error) {
+    numbers, err := table.ArgumentNumbers(arguments, shiftOffset)
+    if err != nil {
+        return Value{}, err
+    }
+    if len(numbers) < 2 {
+        return Value{}, errors.New("Variance needs at least two numbers")
+    }
+
+    mean := sumOf(numbers) / float64(len(numbers))
+    total := 0.0
+    for _, number := range numbers {
+        total += (number - mean) * (number - mean)
+    }
+    return NumberValue(total / float64(len(numbers) - 1)), nil
+}
+
+func stdev(table *Table,
+           arguments []*Expression,
+           shiftOffset CellPosition) (Value, error) {
+    value, err := variance(table, arguments, shiftOffset)
+    if err != nil {
+        return Value{}, err
+    }
+    return NumberValue(math.Sqrt(value.number)), nil
+}
+
+func product(table *Table,
+             arguments []*Expression,
+             shiftOffset CellPosition) (Value, error) {
+    numbers, err := table.ArgumentNumbers(arguments, shiftOffset)
+    if err != nil {
+        return Value{}, err
+    }
+    if len(numbers) == 0 {
+        return NumberValue(0), nil
+    }
+
+    result := 1.0
+    for _, number := range numbers {
+        result *= number
+    }
+    return NumberValue(result), nil
+}
+
+// Interpolates between the closest ranks, with p going from 0 to 1.
+func percentile(table *Table,
+                arguments []*Expression,
+                shiftOffset CellPosition) (Value, error) {
+    numbers, err := table.ArgumentNumbers(arguments[:1], shiftOffset)
+    if err != nil {
+        return Value{}, err
+    }
+    if len(numbers) == 0 {
+        return Value{}, errors.New("Cannot take a percentile of an empty range")
+    }
+
+    p, err := table.EvaluateNumberArgument(arguments, 1, shiftOffset)
+    if err != nil {
+        return Value{}, err
+    }
+    if p < 0 || p > 1 {
+        return Value{}, fmt.Errorf(
+            "Percentile must be between 0 and 1, got %s",
+            formatCellNumber(p))
+    }
+
+    sort.Float64s(numbers)
+    rank := p * float64(len(numbers) - 1)
+    lower := int(math.Floor(rank))
+    if lower + 1 >= len(numbers) {
+        return NumberValue(numbers[lower]), nil
+    }
+
+    fraction := rank - float64(lower)
+    return NumberValue(numbers[lower] + fraction * (numbers[lower + 1] - numbers[lower])), nil
+}
+
+func sumOf(numbers []float64) float64 {
+    total := 0.0
+    for _, number := range numbers {
+        total += number
+    }
+    return total
+}
@@ -2,34 +2,74 @@ package main
 
 import (
 	"bufio"
-	"bytes"
 	"errors"
+	"fmt"
 	"io"
-	"os"
 	"strconv"
 	"strings"
 )
 
 type Table struct {
     allocator ExpressionAllocator
+    functions *FunctionRegistry
     content []Cell
     rows int
     columns int
+    blocks []TableBlock
+    sources []blockSource
+    names map[string]Expression
+
+    // Directives that couldn't be read, which the table is read without.
+    diagnostics []Diagnostic
+
+    evaluated bool
+    graph DependencyGraph
+    clones EdgeList
+    dirty []int
 }
 
 func (table *Table) CellAt(position CellPosition) *Cell {
     if position.row < 0 || position.row >= table.rows ||
         position.column < 0 || position.column >= table.columns {
-        return &Cell {
-            kind: CellError,
-            err: errors.New("Cell outside table"),
-        }
+        cell := errorCell(errors.New("Cell outside table"))
+        return &cell
     }
 
     index := position.row * table.columns + position.column
     return &table.content[index]
 }
 
+// Shifts the range, then fills in any open ends against the current size
+// of its block. A range reaching past the edge of its block is an error,
+// found before any of its cells are walked, so one like A1:ZZZZ99999
+// doesn't visit billions of cells that can't exist.
+func (table *Table) resolveRange(cellRange Range, shiftOffset CellPosition) (Range, error) {
+    r := cellRange.Shift(shiftOffset)
+    block := table.blocks[r.block]
+    end := block.start + block.rows - 1
+    if r.openRow {
+        r.end.row = end
+    }
+    if r.openColumn {
+        r.end.column = block.columns - 1
+    }
+
+    if r.toSeporator {
+        r.end.row = end
+        for row := r.start.row; row <= end; row++ {
+            if table.CellAt(CellPosition { row, r.start.column }).kind == CellSeporator {
+                r.end.row = row - 1
+                break
+            }
+        }
+    }
+
+    if !table.inBlock(r.start, r.block) || !table.inBlock(r.end, r.block) {
+        return Range{}, errors.New("Range outside table")
+    }
+    return r, nil
+}
+
 func (table *Table) IsEmpty(position CellPosition) bool {
     if position.row < 0 || position.row >= table.rows ||
         position.column < 0 || position.column >= table.columns {
@@ -40,60 +80,181 @@ func (table *Table) IsEmpty(position CellPosition) bool {
     return table.content[index].kind == CellEmpty
 }
 
-func (table *Table) Print(output io.Writer) {
-    cellTexts := make([]string, table.rows * table.columns)
-    widths := make([]int, table.columns)
-    for row := 0; row < table.rows; row++ {
-        for column := 0; column < table.columns; column++ {
-            index := row * table.columns + column
-            cell := table.content[index].String()
-            cellTexts[index] = cell
+// Options for the aligned text printer. A maxWidth of 0 means columns
+// can be as wide as they need.
+type PrintOptions struct {
+    // Lines numbers up on their decimal point, instead of their last digit.
+    alignDecimal bool
+
+    // Text wider than maxWidth is cut short, or wrapped onto more lines
+    // if wrap is set. Numbers are always printed in full.
+    maxWidth int
+    wrap bool
+}
 
-            width := len(cell)
-            if width > widths[column] {
-                widths[column] = width
+// Prints each block as its own table, with the block's name above it.
+func (table *Table) Print(output io.Writer) error {
+    return table.PrintWith(output, PrintOptions{})
+}
+
+// Gives the first error writing the output, if there was one, which the
+// buffered writer holds on to until it's flushed.
+func (table *Table) PrintWith(output io.Writer, options PrintOptions) error {
+    writer := bufio.NewWriter(output)
+    for i, block := range table.outputBlocks() {
+        if i > 0 {
+            writer.WriteByte('\n')
+        }
+        if block.name != "" {
+            fmt.Fprintf(writer, "[%s]\n", block.name)
+        }
+
+        table.printBlock(writer, block, options)
+    }
+    return writer.Flush()
+}
+
+// Splits text that's wider than maxWidth onto more lines, or cuts it
+// short. Gives nil if the text fits as it is.
+func (options PrintOptions) fitText(text string) []string {
+    if options.maxWidth <= 0 || displayWidth(text) <= options.maxWidth {
+        return nil
+    }
+    if options.wrap {
+        return wrapWidth(text, options.maxWidth)
+    }
+    return []string { truncateWidth(text, options.maxWidth) }
+}
+
+// The width of the number after its decimal point, including the point.
+func fractionWidth(text string) int {
+    point := strings.LastIndexByte(text, '.')
+    if point == -1 {
+        return 0
+    }
+    return displayWidth(text[point:])
+}
+
+func writePadding(output *bufio.Writer, count int) {
+    for ; count > 0; count-- {
+        output.WriteByte(' ')
+    }
+}
+
+// Numbers are right aligned, or lined up on the decimal point, and
+// everything else is left aligned. Only text is ever cut short or
+// wrapped, columns are made wide enough for every number in them.
+func (table *Table) printBlock(output *bufio.Writer, block TableBlock, options PrintOptions) {
+    start, end := block.start, block.start + block.rows
+    texts := make([]string, block.rows * block.columns)
+    widths := make([]int, block.columns)
+    wholes := make([]int, block.columns)
+    fractions := make([]int, block.columns)
+    for row := start; row < end; row++ {
+        for column := 0; column < block.columns; column++ {
+            cell := table.CellAt(CellPosition { row, column })
+            text := cell.String()
+            texts[(row - start) * block.columns + column] = text
+
+            width := displayWidth(text)
+            switch {
+            case cell.Value().kind == ValueNumber && options.alignDecimal:
+                fraction := fractionWidth(text)
+                wholes[column] = maxInt(wholes[column], width - fraction)
+                fractions[column] = maxInt(fractions[column], fraction)
+            case cell.Value().kind == ValueNumber:
+                widths[column] = maxInt(widths[column], width)
+            default:
+                if lines := options.fitText(text); lines != nil {
+                    width = 0
+                    for _, line := range lines {
+                        width = maxInt(width, displayWidth(line))
+                    }
+                }
+                widths[column] = maxInt(widths[column], width)
             }
         }
     }
+    for column := range widths {
+        widths[column] = maxInt(widths[column], wholes[column] + fractions[column])
+    }
 
-    for row := 0; row < table.rows; row++ {
-        last_non_empty := table.columns - 1
-        for last_non_empty > 0 && table.IsEmpty(CellPosition { row, last_non_empty - 1 }) {
+    lines := make([][]string, block.columns)
+    for row := start; row < end; row++ {
+        last_non_empty := block.columns - 1
+        for last_non_empty > 0 && table.IsEmpty(CellPosition { row, last_non_empty }) {
             last_non_empty -= 1
         }
 
+        rowTexts := texts[(row - start) * block.columns:(row - start + 1) * block.columns]
+        height := 1
         for column := 0; column < last_non_empty + 1; column++ {
-            index := row * table.columns + column
-            text, cell := cellTexts[index], table.content[index]
-            if cell.kind == CellSeporator {
-                text = strings.Repeat("_", widths[column])
+            lines[column] = nil
+            if table.CellAt(CellPosition { row, column }).Value().kind != ValueNumber {
+                lines[column] = options.fitText(rowTexts[column])
+                height = maxInt(height, len(lines[column]))
             }
+        }
+
+        for line := 0; line < height; line++ {
+            for column := 0; column < last_non_empty + 1; column++ {
+                cell := table.CellAt(CellPosition { row, column })
+                text := ""
+                switch {
+                case lines[column] != nil && line < len(lines[column]):
+                    text = lines[column][line]
+                case lines[column] == nil && line == 0:
+                    text = rowTexts[column]
+                }
+                if cell.kind == CellSeporator && line == 0 {
+                    text = strings.Repeat("_", widths[column])
+                }
+
+                last := column == last_non_empty
+                padding := widths[column] - displayWidth(text)
+                if cell.Value().kind == ValueNumber && line == 0 {
+                    after := 0
+                    if options.alignDecimal {
+                        after = fractions[column] - fractionWidth(text)
+                    }
+
+                    writePadding(output, padding - after)
+                    output.WriteString(text)
+                    if !last {
+                        writePadding(output, after)
+                    }
+                } else {
+                    output.WriteString(text)
+                    if !last {
+                        writePadding(output, padding)
+                    }
+                }
 
-            padding := widths[column] - len(text)
-            output.Write(bytes.Repeat([]byte{ ' ' }, padding))
-            output.Write([]byte(text))
-            if column != last_non_empty {
-                output.Write([]byte(" | "))
+                if !last {
+                    output.WriteString(" | ")
+                }
             }
+            output.WriteByte('\n')
         }
-        output.Write([]byte{ '\n' })
     }
 }
 
-func countTableSize(input string) (int, int) {
+func countTableSize(source *blockSource) (int, int) {
     rowCount := 0
     maxColumnCount := 0
 
-    scanner := bufio.NewScanner(strings.NewReader(input))
-    for scanner.Scan() {
-        line := strings.TrimSpace(scanner.Text())
-        if len(line) >= 3 && line[:3] == "..." {
+    for _, record := range source.records {
+        if _, ok := source.directive(record); ok {
+            continue
+        }
+
+        if line, ok := source.cloneLine(record); ok {
             count, _ := strconv.ParseUint(strings.TrimSpace(line[3:]), 10, 32)
             rowCount += int(count)
             continue
         }
 
-        columnCount := strings.Count(line, "|") + 1
+        columnCount := len(record)
         rowCount += 1
         if columnCount > maxColumnCount {
             maxColumnCount = columnCount
@@ -103,8 +264,7 @@ func countTableSize(input string) (int, int) {
     return rowCount, maxColumnCount
 }
 
-func readCloneLine(allocator *ExpressionAllocator,
-                   content []Cell,
+func readCloneLine(content []Cell,
                    columns int,
                    line string,
                    row int) (int, error) {
@@ -127,34 +287,30 @@ func readCloneLine(allocator *ExpressionAllocator,
     return row, nil
 }
 
-func readTableContent(allocator *ExpressionAllocator,
-                      input string,
-                      rows int,
-                      columns int) ([]Cell, error) {
-    content := make([]Cell, rows * columns)
-    for i := range content {
-        content[i] = Cell { kind: CellEmpty }
-    }
+func (table *Table) readBlockContent(block int, source *blockSource) error {
+    columns := table.columns
+    content := table.content[table.blocks[block].start * columns:]
 
-    scanner := bufio.NewScanner(strings.NewReader(input))
     row := 0
-    for scanner.Scan() {
-        line := strings.TrimSpace(scanner.Text())
-        if len(line) >= 3 && line[:3] == "..." {
-            new_row, err := readCloneLine(allocator, content, columns, line, row)
+    for _, record := range source.records {
+        if _, ok := source.directive(record); ok {
+            continue
+        }
+
+        if line, ok := source.cloneLine(record); ok {
+            new_row, err := readCloneLine(content, columns, line, row)
             if err != nil {
-                return nil, err
+                return err
             }
 
             row = new_row
             continue
         }
 
-        cells := strings.Split(line, "|")
-        for column := 0; column < len(cells); column++ {
-            text := strings.TrimSpace(cells[column])
+        for column := 0; column < len(record); column++ {
+            text := record[column]
             position := CellPosition { row, column }
-            cell := parseCell(allocator, text, position)
+            cell := table.parseCellAt(text, block, position)
 
             /*
             if cell.kind == CellClone && cell.direction.IsUpOrLeft() {
@@ -173,28 +329,69 @@ func readTableContent(allocator *ExpressionAllocator,
         row += 1
     }
 
-    return content, nil
+    return nil
 }
 
-func readTable(filePath string) (Table, error) {
-    input_bytes, err := os.ReadFile(filePath)
+func readSourcesAs(filePath string, format string) ([]blockSource, error) {
+    format, err := inputFormat(filePath, format)
     if err != nil {
-        return Table{}, err
+        return nil, err
+    }
+
+    switch format {
+    case "csv":
+        return readDelimitedSources(filePath, ',')
+    case "tsv":
+        return readDelimitedSources(filePath, '\t')
+    default:
+        return readSources(filePath)
     }
-    
-    input := string(input_bytes)
-    rows, columns := countTableSize(input)
-    allocator := newExpressionAllocator()
-    content, err := readTableContent(&allocator, input, rows, columns)
+}
+
+// Reads a table from a .cell, CSV or TSV file. The format is picked from
+// the file's extension if it's empty.
+func readTable(filePath string, format string) (Table, error) {
+    sources, err := readSourcesAs(filePath, format)
     if err != nil {
         return Table{}, err
     }
 
-    return Table {
-        allocator,
-        content,
-        rows,
-        columns,
-    }, nil
+    table := Table {
+        allocator: newExpressionAllocator(),
+        functions: DefaultFunctions,
+        sources: sources,
+        names: make(map[string]Expression),
+    }
+    for _, source := range sources {
+        rows, columns := countTableSize(&source)
+        table.blocks = append(table.blocks, TableBlock {
+            name: source.name,
+            start: table.rows,
+            rows: rows,
+            columns: columns,
+            included: source.included,
+        })
+
+        table.rows += rows
+        if columns > table.columns {
+            table.columns = columns
+        }
+    }
+
+    table.content = make([]Cell, table.rows * table.columns)
+    for i := range table.content {
+        table.content[i] = Cell { kind: CellEmpty }
+    }
+
+    for block := range sources {
+        table.readNames(block, &sources[block])
+        if err := table.readBlockContent(block, &sources[block]); err != nil {
+            return Table{}, err
+        }
+    }
+
+    table.applyColumnFormats()
+    table.resolveNames()
+    return table, nil
 }
 
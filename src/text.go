@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+func length(table *Table,
+            arguments []*Expression,
+            shiftOffset CellPosition) (Value, error) {
+    text, err := table.EvaluateStringArgument(arguments, 0, shiftOffset)
+    if err != nil {
+        return Value{}, err
+    }
+
+    return NumberValue(float64(len([]rune(text)))), nil
+}
+
+func upper(table *Table,
+           arguments []*Expression,
+           shiftOffset CellPosition) (Value, error) {
+    text, err := table.EvaluateStringArgument(arguments, 0, shiftOffset)
+    if err != nil {
+        return Value{}, err
+    }
+
+    return StringValue(strings.ToUpper(text)), nil
+}
+
+func lower(table *Table,
+           arguments []*Expression,
+           shiftOffset CellPosition) (Value, error) {
+    text, err := table.EvaluateStringArgument(arguments, 0, shiftOffset)
+    if err != nil {
+        return Value{}, err
+    }
+
+    return StringValue(strings.ToLower(text)), nil
+}
+
+func trim(table *Table,
+          arguments []*Expression,
+          shiftOffset CellPosition) (Value, error) {
+    text, err := table.EvaluateStringArgument(arguments, 0, shiftOffset)
+    if err != nil {
+        return Value{}, err
+    }
+
+    return StringValue(strings.TrimSpace(text)), nil
+}
+
+// Joins every argument together, including each cell of a range in row
+// order.
+func concat(table *Table,
+            arguments []*Expression,
+            shiftOffset CellPosition) (Value, error) {
+    var builder strings.Builder
+    for i := range arguments {
+        values, err := table.EvaluateRangeArgument(arguments, i, shiftOffset)
+        if err != nil {
+            return Value{}, err
+        }
+
+        for _, value := range values {
+            builder.WriteString(value.String())
+        }
+    }
+
+    return StringValue(builder.String()), nil
+}
+
+func (table *Table) EvaluateCountArgument(arguments []*Expression,
+                                          index int,
+                                          shiftOffset CellPosition) (int, error) {
+    count, err := table.EvaluateNumberArgument(arguments, index, shiftOffset)
+    if err != nil {
+        return -1, err
+    }
+
+    if count < 0 || count != float64(int(count)) {
+        return -1, NewArgumentError(index, fmt.Errorf(
+            "Expected a whole number of characters, got %s",
+            formatCellNumber(count)))
+    }
+
+    return int(count), nil
+}
+
+func minInt(a int, b int) int {
+    if a < b {
+        return a
+    }
+    return b
+}
+
+func maxInt(a int, b int) int {
+    if a > b {
+        return a
+    }
+    return b
+}
+
+func left(table *Table,
+          arguments []*Expression,
+          shiftOffset CellPosition) (Value, error) {
+    text, err := table.EvaluateStringArgument(arguments, 0, shiftOffset)
+    if err != nil {
+        return Value{}, err
+    }
+
+    count, err := table.EvaluateCountArgument(arguments, 1, shiftOffset)
+    if err != nil {
+        return Value{}, err
+    }
+
+    runes := []rune(text)
+    return StringValue(string(runes[:minInt(count, len(runes))])), nil
+}
+
+func right(table *Table,
+           arguments []*Expression,
+           shiftOffset CellPosition) (Value, error) {
+    text, err := table.EvaluateStringArgument(arguments, 0, shiftOffset)
+    if err != nil {
+        return Value{}, err
+    }
+
+    count, err := table.EvaluateCountArgument(arguments, 1, shiftOffset)
+    if err != nil {
+        return Value{}, err
+    }
+
+    runes := []rune(text)
+    return StringValue(string(runes[len(runes) - minInt(count, len(runes)):])), nil
+}
+
+// Takes count characters starting at the 1-based start position.
+func mid(table *Table,
+         arguments []*Expression,
+         shiftOffset CellPosition) (Value, error) {
+    text, err := table.EvaluateStringArgument(arguments, 0, shiftOffset)
+    if err != nil {
+        return Value{}, err
+    }
+
+    start, err := table.EvaluateCountArgument(arguments, 1, shiftOffset)
+    if err != nil {
+        return Value{}, err
+    }
+    if start < 1 {
+        return Value{}, NewArgumentError(1, fmt.Errorf(
+            "Start must be at least 1, got %d", start))
+    }
+
+    count, err := table.EvaluateCountArgument(arguments, 2, shiftOffset)
+    if err != nil {
+        return Value{}, err
+    }
+
+    runes := []rune(text)
+    from := minInt(start - 1, len(runes))
+    to := minInt(from + count, len(runes))
+    return StringValue(string(runes[from:to])), nil
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+type ValueKind int
+const (
+    ValueEmpty ValueKind = iota
+    ValueNumber
+    ValueString
+    ValueBoolean
+    ValueError
+)
+
+type Value struct {
+    kind ValueKind
+    number float64
+    text string
+    boolean bool
+    err error
+}
+
+func NumberValue(number float64) Value {
+    return Value { kind: ValueNumber, number: number }
+}
+
+func StringValue(text string) Value {
+    return Value { kind: ValueString, text: text }
+}
+
+func BooleanValue(boolean bool) Value {
+    return Value { kind: ValueBoolean, boolean: boolean }
+}
+
+func ErrorValue(err error) Value {
+    return Value { kind: ValueError, err: err }
+}
+
+func (value Value) String() string {
+    switch value.kind {
+    case ValueEmpty:
+        return ""
+    case ValueNumber:
+        return formatCellNumber(value.number)
+    case ValueString:
+        return value.text
+    case ValueBoolean:
+        if value.boolean {
+            return "TRUE"
+        }
+        return "FALSE"
+    case ValueError:
+        return "#" + fmt.Sprint(value.err) + "#"
+    default:
+        panic(0)
+    }
+}
+
+func (value Value) AsNumber() (float64, error) {
+    switch value.kind {
+    case ValueEmpty:
+        return 0, nil
+    case ValueNumber:
+        return value.number, nil
+    case ValueString:
+        return -1, errors.New("Cannot operate on text")
+    case ValueBoolean:
+        if value.boolean {
+            return 1, nil
+        }
+        return 0, nil
+    case ValueError:
+        return -1, value.err
+    default:
+        panic(0)
+    }
+}
+
+func (value Value) Kind() ValueKind {
+    return value.kind
+}
+
+func (value Value) AsBoolean() (bool, error) {
+    switch value.kind {
+    case ValueEmpty:
+        return false, nil
+    case ValueNumber:
+        return value.number != 0, nil
+    case ValueString:
+        return false, errors.New("Cannot use text as a condition")
+    case ValueBoolean:
+        return value.boolean, nil
+    case ValueError:
+        return false, value.err
+    default:
+        panic(0)
+    }
+}
+
+func (value Value) isText() bool {
+    return value.kind == ValueString || value.kind == ValueEmpty
+}
+
+// Orders numbers numerically and text alphabetically. Empty values can
+// be compared with either.
+func compareValues(lhs Value, rhs Value) (int, error) {
+    if lhs.kind == ValueError {
+        return 0, lhs.err
+    }
+    if rhs.kind == ValueError {
+        return 0, rhs.err
+    }
+
+    if lhs.kind == ValueString || rhs.kind == ValueString {
+        if !lhs.isText() || !rhs.isText() {
+            return 0, errors.New("Cannot compare text with a number")
+        }
+        return strings.Compare(lhs.text, rhs.text), nil
+    }
+
+    a, _ := lhs.AsNumber()
+    b, _ := rhs.AsNumber()
+    switch {
+    case a < b: return -1, nil
+    case a > b: return 1, nil
+    default: return 0, nil
+    }
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Characters that take up two columns in a terminal, such as CJK
+// ideographs, Hangul and full width forms.
+var wideRanges = [][2]rune {
+    { 0x1100, 0x115F },
+    { 0x2E80, 0x303E },
+    { 0x3041, 0x33FF },
+    { 0x3400, 0x4DBF },
+    { 0x4E00, 0x9FFF },
+    { 0xA000, 0xA4CF },
+    { 0xAC00, 0xD7A3 },
+    { 0xF900, 0xFAFF },
+    { 0xFE30, 0xFE4F },
+    { 0xFF00, 0xFF60 },
+    { 0xFFE0, 0xFFE6 },
+    { 0x1F300, 0x1F64F },
+    { 0x1F900, 0x1F9FF },
+    { 0x20000, 0x2FFFD },
+    { 0x30000, 0x3FFFD },
+}
+
+func runeWidth(r rune) int {
+    if unicode.IsControl(r) || unicode.In(r, unicode.Mn, unicode.Me, unicode.Cf) {
+        return 0
+    }
+
+    for _, wide := range wideRanges {
+        if r >= wide[0] && r <= wide[1] {
+            return 2
+        }
+    }
+    return 1
+}
+
+// The number of columns text takes up when printed.
+func displayWidth(text string) int {
+    width := 0
+    for _, r := range text {
+        width += runeWidth(r)
+    }
+    return width
+}
+
+// Cuts text down to fit in width columns, ending it with '…' if anything
+// was cut off.
+func truncateWidth(text string, width int) string {
+    if displayWidth(text) <= width {
+        return text
+    }
+
+    var builder strings.Builder
+    used := 0
+    for _, r := range text {
+        if used + runeWidth(r) > width - 1 {
+            break
+        }
+
+        builder.WriteRune(r)
+        used += runeWidth(r)
+    }
+
+    builder.WriteRune('…')
+    return builder.String()
+}
+
+// Splits text into lines no wider than width, breaking between words
+// where it can.
+func wrapWidth(text string, width int) []string {
+    lines := make([]string, 0, 1)
+    line, used := "", 0
+    for _, word := range strings.Fields(text) {
+        for displayWidth(word) > width {
+            if used > 0 {
+                lines = append(lines, line)
+                line, used = "", 0
+            }
+
+            var head strings.Builder
+            headWidth := 0
+            rest := ""
+            for i, r := range word {
+                if headWidth + runeWidth(r) > width && headWidth > 0 {
+                    rest = word[i:]
+                    break
+                }
+                head.WriteRune(r)
+                headWidth += runeWidth(r)
+            }
+
+            lines = append(lines, head.String())
+            word = rest
+        }
+
+        switch {
+        case used == 0:
+            line, used = word, displayWidth(word)
+        case used + 1 + displayWidth(word) <= width:
+            line, used = line + " " + word, used + 1 + displayWidth(word)
+        default:
+            lines = append(lines, line)
+            line, used = word, displayWidth(word)
+        }
+    }
+
+    if used > 0 || len(lines) == 0 {
+        lines = append(lines, line)
+    }
+    return lines
+}